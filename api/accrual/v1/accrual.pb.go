@@ -0,0 +1,200 @@
+// Контракт gRPC-транспорта системы начислений. Go-код в этом каталоге
+// сгенерирован из него; после изменения файла выполните go generate
+// ./api/... (нужны protoc, protoc-gen-go и protoc-gen-go-grpc).
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.8
+// 	protoc        (unknown)
+// source: accrual/v1/accrual.proto
+
+package accrualv1
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type GetOrderRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Order         string                 `protobuf:"bytes,1,opt,name=order,proto3" json:"order,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetOrderRequest) Reset() {
+	*x = GetOrderRequest{}
+	mi := &file_accrual_v1_accrual_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetOrderRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetOrderRequest) ProtoMessage() {}
+
+func (x *GetOrderRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_accrual_v1_accrual_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetOrderRequest.ProtoReflect.Descriptor instead.
+func (*GetOrderRequest) Descriptor() ([]byte, []int) {
+	return file_accrual_v1_accrual_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *GetOrderRequest) GetOrder() string {
+	if x != nil {
+		return x.Order
+	}
+	return ""
+}
+
+type GetOrderResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Order string                 `protobuf:"bytes,1,opt,name=order,proto3" json:"order,omitempty"`
+	// REGISTERED, PROCESSING, INVALID или PROCESSED
+	Status        string   `protobuf:"bytes,2,opt,name=status,proto3" json:"status,omitempty"`
+	Accrual       *float64 `protobuf:"fixed64,3,opt,name=accrual,proto3,oneof" json:"accrual,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetOrderResponse) Reset() {
+	*x = GetOrderResponse{}
+	mi := &file_accrual_v1_accrual_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetOrderResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetOrderResponse) ProtoMessage() {}
+
+func (x *GetOrderResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_accrual_v1_accrual_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetOrderResponse.ProtoReflect.Descriptor instead.
+func (*GetOrderResponse) Descriptor() ([]byte, []int) {
+	return file_accrual_v1_accrual_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *GetOrderResponse) GetOrder() string {
+	if x != nil {
+		return x.Order
+	}
+	return ""
+}
+
+func (x *GetOrderResponse) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *GetOrderResponse) GetAccrual() float64 {
+	if x != nil && x.Accrual != nil {
+		return *x.Accrual
+	}
+	return 0
+}
+
+var File_accrual_v1_accrual_proto protoreflect.FileDescriptor
+
+const file_accrual_v1_accrual_proto_rawDesc = "" +
+	"\n" +
+	"\x18accrual/v1/accrual.proto\x12\n" +
+	"accrual.v1\"'\n" +
+	"\x0fGetOrderRequest\x12\x14\n" +
+	"\x05order\x18\x01 \x01(\tR\x05order\"k\n" +
+	"\x10GetOrderResponse\x12\x14\n" +
+	"\x05order\x18\x01 \x01(\tR\x05order\x12\x16\n" +
+	"\x06status\x18\x02 \x01(\tR\x06status\x12\x1d\n" +
+	"\aaccrual\x18\x03 \x01(\x01H\x00R\aaccrual\x88\x01\x01B\n" +
+	"\n" +
+	"\b_accrual2W\n" +
+	"\x0eAccrualService\x12E\n" +
+	"\bGetOrder\x12\x1b.accrual.v1.GetOrderRequest\x1a\x1c.accrual.v1.GetOrderResponseB?Z=github.com/Bekw/go-practicum-diploma/api/accrual/v1;accrualv1b\x06proto3"
+
+var (
+	file_accrual_v1_accrual_proto_rawDescOnce sync.Once
+	file_accrual_v1_accrual_proto_rawDescData []byte
+)
+
+func file_accrual_v1_accrual_proto_rawDescGZIP() []byte {
+	file_accrual_v1_accrual_proto_rawDescOnce.Do(func() {
+		file_accrual_v1_accrual_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_accrual_v1_accrual_proto_rawDesc), len(file_accrual_v1_accrual_proto_rawDesc)))
+	})
+	return file_accrual_v1_accrual_proto_rawDescData
+}
+
+var file_accrual_v1_accrual_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_accrual_v1_accrual_proto_goTypes = []any{
+	(*GetOrderRequest)(nil),  // 0: accrual.v1.GetOrderRequest
+	(*GetOrderResponse)(nil), // 1: accrual.v1.GetOrderResponse
+}
+var file_accrual_v1_accrual_proto_depIdxs = []int32{
+	0, // 0: accrual.v1.AccrualService.GetOrder:input_type -> accrual.v1.GetOrderRequest
+	1, // 1: accrual.v1.AccrualService.GetOrder:output_type -> accrual.v1.GetOrderResponse
+	1, // [1:2] is the sub-list for method output_type
+	0, // [0:1] is the sub-list for method input_type
+	0, // [0:0] is the sub-list for extension type_name
+	0, // [0:0] is the sub-list for extension extendee
+	0, // [0:0] is the sub-list for field type_name
+}
+
+func init() { file_accrual_v1_accrual_proto_init() }
+func file_accrual_v1_accrual_proto_init() {
+	if File_accrual_v1_accrual_proto != nil {
+		return
+	}
+	file_accrual_v1_accrual_proto_msgTypes[1].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_accrual_v1_accrual_proto_rawDesc), len(file_accrual_v1_accrual_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   2,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_accrual_v1_accrual_proto_goTypes,
+		DependencyIndexes: file_accrual_v1_accrual_proto_depIdxs,
+		MessageInfos:      file_accrual_v1_accrual_proto_msgTypes,
+	}.Build()
+	File_accrual_v1_accrual_proto = out.File
+	file_accrual_v1_accrual_proto_goTypes = nil
+	file_accrual_v1_accrual_proto_depIdxs = nil
+}
@@ -0,0 +1,5 @@
+// Package accrualv1 — сгенерированный из accrual.proto код gRPC-контракта
+// системы начислений.
+package accrualv1
+
+//go:generate protoc -I ../.. --go_out=../.. --go_opt=paths=source_relative --go-grpc_out=../.. --go-grpc_opt=paths=source_relative accrual/v1/accrual.proto
@@ -0,0 +1,34 @@
+// accrual-sim — локальная замена системы начислений для end-to-end
+// проверок Processor без сети. Поведение задаётся файлом сценария
+// (см. scenario.example.yaml).
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"os"
+)
+
+func main() {
+	addr := "localhost:8081"
+	if v := os.Getenv("RUN_ADDRESS"); v != "" {
+		addr = v
+	}
+	scenarioPath := os.Getenv("ACCRUAL_SCENARIO")
+
+	flag.StringVar(&addr, "a", addr, "server address")
+	flag.StringVar(&scenarioPath, "s", scenarioPath, "scenario file (YAML or JSON), empty for the default scenario")
+	flag.Parse()
+
+	scenario, err := loadScenario(scenarioPath)
+	if err != nil {
+		log.Fatalf("failed to load scenario: %v", err)
+	}
+
+	log.Printf("accrual simulator on %s, %d rules", addr, len(scenario.Rules))
+
+	if err := http.ListenAndServe(addr, newServer(scenario).routes()); err != nil {
+		log.Fatalf("server stopped: %v", err)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Scenario описывает поведение симулятора. Файл читается как YAML, поэтому
+// JSON тоже подходит.
+type Scenario struct {
+	// Rules проверяются по порядку; номер, не подошедший ни одному правилу,
+	// не зарегистрирован (204).
+	Rules []Rule `yaml:"rules"`
+	// FailRate — доля запросов, на которые отвечается 500, от 0 до 1.
+	FailRate float64 `yaml:"fail_rate"`
+	// RateLimit ограничивает число запросов; превышение — 429.
+	RateLimit *RateLimit `yaml:"rate_limit"`
+	// Batch включает POST /api/orders/batch. Без него — 404, и клиент
+	// переходит на запросы по одному.
+	Batch bool `yaml:"batch"`
+}
+
+// Rule задаёт ответ для подходящих номеров. Отсчёт времени ведётся от
+// первого запроса по номеру: Registered в статусе REGISTERED, затем
+// Processing в PROCESSING, затем окончательный Status.
+type Rule struct {
+	Numbers []string `yaml:"numbers"`
+	Match   string   `yaml:"match"`
+
+	// Status — окончательный статус: PROCESSED (по умолчанию) или INVALID.
+	Status  string  `yaml:"status"`
+	Accrual float64 `yaml:"accrual"`
+
+	Registered Duration `yaml:"registered"`
+	Processing Duration `yaml:"processing"`
+
+	re *regexp.Regexp
+}
+
+type RateLimit struct {
+	Requests int      `yaml:"requests"`
+	Per      Duration `yaml:"per"`
+	// RetryAfter — значение заголовка Retry-After; по умолчанию Per.
+	// Дробные секунды округляются вверх.
+	RetryAfter Duration `yaml:"retry_after"`
+}
+
+// Duration читается из строки формата time.ParseDuration ("1.5s", "2m").
+type Duration time.Duration
+
+func (d *Duration) UnmarshalYAML(n *yaml.Node) error {
+	var s string
+	if err := n.Decode(&s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// defaultScenario используется без файла: любой номер обрабатывается
+// за две секунды с начислением 100.
+func defaultScenario() *Scenario {
+	return &Scenario{
+		Rules: []Rule{{
+			Match:      ".*",
+			Status:     "PROCESSED",
+			Accrual:    100,
+			Registered: Duration(time.Second),
+			Processing: Duration(time.Second),
+		}},
+		Batch: true,
+	}
+}
+
+// loadScenario читает сценарий из файла; пустой path — defaultScenario.
+// В обоих случаях сценарий проходит validate, которая и компилирует Match.
+func loadScenario(path string) (*Scenario, error) {
+	if path == "" {
+		s := defaultScenario()
+		if err := s.validate(); err != nil {
+			return nil, fmt.Errorf("default scenario: %w", err)
+		}
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var s Scenario
+	if err := yaml.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if err := s.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &s, nil
+}
+
+func (s *Scenario) validate() error {
+	if s.FailRate < 0 || s.FailRate > 1 {
+		return fmt.Errorf("fail_rate must be between 0 and 1")
+	}
+	if rl := s.RateLimit; rl != nil {
+		if rl.Requests <= 0 || rl.Per <= 0 {
+			return fmt.Errorf("rate_limit needs positive requests and per")
+		}
+		if rl.RetryAfter < 0 {
+			return fmt.Errorf("rate_limit retry_after must not be negative")
+		}
+		if rl.RetryAfter == 0 {
+			rl.RetryAfter = rl.Per
+		}
+	}
+
+	for i := range s.Rules {
+		r := &s.Rules[i]
+		switch r.Status {
+		case "":
+			r.Status = "PROCESSED"
+		case "PROCESSED", "INVALID":
+		default:
+			return fmt.Errorf("rule %d: status must be PROCESSED or INVALID", i)
+		}
+		if r.Status == "INVALID" && r.Accrual != 0 {
+			return fmt.Errorf("rule %d: INVALID orders have no accrual", i)
+		}
+		if r.Match != "" {
+			re, err := regexp.Compile(r.Match)
+			if err != nil {
+				return fmt.Errorf("rule %d: %w", i, err)
+			}
+			r.re = re
+		}
+		if len(r.Numbers) == 0 && r.re == nil {
+			return fmt.Errorf("rule %d: numbers or match is required", i)
+		}
+	}
+	return nil
+}
+
+// rule возвращает первое правило, подходящее номеру.
+func (s *Scenario) rule(number string) *Rule {
+	for i := range s.Rules {
+		r := &s.Rules[i]
+		for _, n := range r.Numbers {
+			if n == number {
+				return r
+			}
+		}
+		if r.re != nil && r.re.MatchString(number) {
+			return r
+		}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScenarioValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       Scenario
+		wantErr string
+	}{
+		{name: "ok", s: Scenario{Rules: []Rule{{Numbers: []string{"1"}}}}},
+		{name: "fail rate", s: Scenario{FailRate: 1.5}, wantErr: "fail_rate"},
+		{name: "rate limit without per", s: Scenario{RateLimit: &RateLimit{Requests: 1}}, wantErr: "rate_limit"},
+		{
+			name:    "negative retry after",
+			s:       Scenario{RateLimit: &RateLimit{Requests: 1, Per: Duration(time.Second), RetryAfter: Duration(-time.Second)}},
+			wantErr: "retry_after",
+		},
+		{name: "unknown status", s: Scenario{Rules: []Rule{{Numbers: []string{"1"}, Status: "DONE"}}}, wantErr: "status"},
+		{name: "invalid with accrual", s: Scenario{Rules: []Rule{{Numbers: []string{"1"}, Status: "INVALID", Accrual: 10}}}, wantErr: "no accrual"},
+		{name: "bad regexp", s: Scenario{Rules: []Rule{{Match: "("}}}, wantErr: "rule 0"},
+		{name: "empty rule", s: Scenario{Rules: []Rule{{Accrual: 10}}}, wantErr: "numbers or match"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.s.validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("validate: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("validate error %v, want containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestScenarioDefaults(t *testing.T) {
+	s := Scenario{
+		Rules:     []Rule{{Numbers: []string{"1"}}},
+		RateLimit: &RateLimit{Requests: 1, Per: Duration(time.Minute)},
+	}
+	if err := s.validate(); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	if s.Rules[0].Status != "PROCESSED" {
+		t.Errorf("default status %q, want PROCESSED", s.Rules[0].Status)
+	}
+	if s.RateLimit.RetryAfter != s.RateLimit.Per {
+		t.Errorf("default retry_after %s, want per", time.Duration(s.RateLimit.RetryAfter))
+	}
+}
+
+func TestScenarioRule(t *testing.T) {
+	s := Scenario{Rules: []Rule{
+		{Numbers: []string{"12345678903"}, Accrual: 1},
+		{Match: "^4", Accrual: 2},
+		{Match: "^[1-9]", Accrual: 3},
+	}}
+	if err := s.validate(); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+
+	tests := []struct {
+		number  string
+		accrual float64
+		found   bool
+	}{
+		{"12345678903", 1, true},
+		{"4111", 2, true},
+		{"5111", 3, true},
+		{"0111", 0, false},
+	}
+	for _, tt := range tests {
+		r := s.rule(tt.number)
+		if (r != nil) != tt.found {
+			t.Fatalf("rule(%s) found = %v, want %v", tt.number, r != nil, tt.found)
+		}
+		if r != nil && r.Accrual != tt.accrual {
+			t.Errorf("rule(%s) = rule with accrual %v, want %v", tt.number, r.Accrual, tt.accrual)
+		}
+	}
+}
+
+func TestLoadExampleScenario(t *testing.T) {
+	s, err := loadScenario("scenario.example.yaml")
+	if err != nil {
+		t.Fatalf("loadScenario: %v", err)
+	}
+	if !s.Batch || s.RateLimit == nil || len(s.Rules) == 0 {
+		t.Fatalf("example scenario parsed incompletely: %+v", s)
+	}
+	if got := time.Duration(s.RateLimit.RetryAfter); got != 10*time.Second {
+		t.Errorf("retry_after = %s, want 10s", got)
+	}
+}
+
+func TestDefaultScenario(t *testing.T) {
+	s, err := loadScenario("")
+	if err != nil {
+		t.Fatalf("loadScenario: %v", err)
+	}
+	srv := newServer(s)
+
+	const number = "79927398713"
+	r, ok := srv.lookup(number)
+	if !ok || r.Status != "REGISTERED" {
+		t.Fatalf("lookup(%s) = %+v, %v, want REGISTERED", number, r, ok)
+	}
+
+	srv.firstSeen[number] = time.Now().Add(-time.Minute)
+	r, ok = srv.lookup(number)
+	if !ok || r.Status != "PROCESSED" || r.Accrual == nil || *r.Accrual != 100 {
+		t.Fatalf("lookup(%s) = %+v, %v, want PROCESSED with accrual 100", number, r, ok)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type orderResponse struct {
+	Order   string   `json:"order"`
+	Status  string   `json:"status"`
+	Accrual *float64 `json:"accrual,omitempty"`
+}
+
+type server struct {
+	scenario *Scenario
+
+	mu          sync.Mutex
+	firstSeen   map[string]time.Time
+	windowStart time.Time
+	windowCount int
+}
+
+func newServer(s *Scenario) *server {
+	return &server{scenario: s, firstSeen: make(map[string]time.Time)}
+}
+
+func (s *server) routes() http.Handler {
+	r := chi.NewRouter()
+	r.Get("/api/orders/{number}", s.handleGetOrder)
+	if s.scenario.Batch {
+		r.Post("/api/orders/batch", s.handleGetOrders)
+	}
+	return r
+}
+
+func (s *server) handleGetOrder(w http.ResponseWriter, r *http.Request) {
+	number := chi.URLParam(r, "number")
+	if !s.admit(w) {
+		return
+	}
+
+	resp, ok := s.lookup(number)
+	log.Printf("GET %s -> %s", number, resp.Status)
+	if !ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// handleGetOrders отвечает на пакетный запрос: в ответе только
+// зарегистрированные номера.
+func (s *server) handleGetOrders(w http.ResponseWriter, r *http.Request) {
+	var numbers []string
+	if err := json.NewDecoder(r.Body).Decode(&numbers); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !s.admit(w) {
+		return
+	}
+
+	res := make([]orderResponse, 0, len(numbers))
+	for _, n := range numbers {
+		if resp, ok := s.lookup(n); ok {
+			res = append(res, resp)
+		}
+	}
+	log.Printf("POST batch of %d -> %d known", len(numbers), len(res))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+// admit применяет лимит запросов и случайные сбои. Возвращает false, если
+// ответ уже записан.
+func (s *server) admit(w http.ResponseWriter) bool {
+	if rl := s.scenario.RateLimit; rl != nil {
+		s.mu.Lock()
+		now := time.Now()
+		if now.Sub(s.windowStart) >= time.Duration(rl.Per) {
+			s.windowStart = now
+			s.windowCount = 0
+		}
+		s.windowCount++
+		limited := s.windowCount > rl.Requests
+		s.mu.Unlock()
+
+		if limited {
+			log.Printf("-> 429")
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(time.Duration(rl.RetryAfter))))
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprintf(w, "No more than %d requests per %s allowed", rl.Requests, time.Duration(rl.Per))
+			return false
+		}
+	}
+
+	if s.scenario.FailRate > 0 && rand.Float64() < s.scenario.FailRate {
+		log.Printf("-> 500")
+		http.Error(w, "injected failure", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// retryAfterSeconds округляет паузу вверх до целых секунд: Retry-After не
+// бывает дробным, а 0 означал бы «повторяйте сразу».
+func retryAfterSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// lookup возвращает текущее состояние номера по сценарию.
+func (s *server) lookup(number string) (orderResponse, bool) {
+	rule := s.scenario.rule(number)
+	if rule == nil {
+		return orderResponse{Status: "unknown"}, false
+	}
+
+	s.mu.Lock()
+	first, ok := s.firstSeen[number]
+	if !ok {
+		first = time.Now()
+		s.firstSeen[number] = first
+	}
+	s.mu.Unlock()
+
+	resp := orderResponse{Order: number}
+	elapsed := time.Since(first)
+	switch {
+	case elapsed < time.Duration(rule.Registered):
+		resp.Status = "REGISTERED"
+	case elapsed < time.Duration(rule.Registered+rule.Processing):
+		resp.Status = "PROCESSING"
+	default:
+		resp.Status = rule.Status
+		if rule.Status == "PROCESSED" {
+			accrual := rule.Accrual
+			resp.Accrual = &accrual
+		}
+	}
+	return resp, true
+}
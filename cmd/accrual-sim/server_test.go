@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Bekw/go-practicum-diploma/internal/accrual"
+)
+
+const step = 100 * time.Millisecond
+
+func newTestServer(t *testing.T, s *Scenario) *httptest.Server {
+	t.Helper()
+	if err := s.validate(); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	srv := httptest.NewServer(newServer(s).routes())
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// newTestClient — тот же клиент, что Processor получает в gophermart.
+func newTestClient(t *testing.T, url string) accrual.Client {
+	t.Helper()
+	c, err := accrual.NewClient(url)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	return c
+}
+
+func TestOrderProgression(t *testing.T) {
+	srv := newTestServer(t, &Scenario{Rules: []Rule{
+		{Numbers: []string{"1"}, Accrual: 729.98, Registered: Duration(step), Processing: Duration(step)},
+		{Numbers: []string{"2"}, Status: "INVALID", Processing: Duration(step)},
+	}})
+	c := newTestClient(t, srv.URL)
+	ctx := context.Background()
+
+	check := func(number, status string, accrual *float64) {
+		t.Helper()
+		r, err := c.GetOrder(ctx, number)
+		if err != nil {
+			t.Fatalf("GetOrder(%s): %v", number, err)
+		}
+		if r.Order != number || r.Status != status {
+			t.Fatalf("GetOrder(%s) = %s %s, want %s", number, r.Order, r.Status, status)
+		}
+		if (r.Accrual == nil) != (accrual == nil) || (accrual != nil && *r.Accrual != *accrual) {
+			t.Fatalf("GetOrder(%s) accrual = %v, want %v", number, r.Accrual, accrual)
+		}
+	}
+
+	check("1", "REGISTERED", nil)
+	check("2", "PROCESSING", nil)
+	time.Sleep(step)
+	check("1", "PROCESSING", nil)
+	check("2", "INVALID", nil)
+	time.Sleep(step)
+	want := 729.98
+	check("1", "PROCESSED", &want)
+}
+
+func TestUnknownOrder(t *testing.T) {
+	srv := newTestServer(t, &Scenario{Rules: []Rule{{Match: "^[1-9]"}}})
+
+	resp, err := http.Get(srv.URL + "/api/orders/0123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("status %d, want 204", resp.StatusCode)
+	}
+
+	c := newTestClient(t, srv.URL)
+	if _, err := c.GetOrder(context.Background(), "0123"); !errors.Is(err, accrual.ErrNotRegistered) {
+		t.Fatalf("GetOrder error %v, want ErrNotRegistered", err)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	srv := newTestServer(t, &Scenario{
+		Rules:     []Rule{{Match: ".*"}},
+		RateLimit: &RateLimit{Requests: 2, Per: Duration(time.Minute), RetryAfter: Duration(1500 * time.Millisecond)},
+	})
+	c := newTestClient(t, srv.URL)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.GetOrder(ctx, "1"); err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+	}
+
+	resp, err := http.Get(srv.URL + "/api/orders/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("status %d, want 429", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Retry-After"); got != "2" {
+		t.Fatalf("Retry-After %q, want 2 (rounded up from 1.5s)", got)
+	}
+
+	var rl *accrual.RateLimitError
+	if _, err := c.GetOrder(ctx, "1"); !errors.As(err, &rl) || rl.RetryAfter != 2*time.Second {
+		t.Fatalf("GetOrder error %v, want RateLimitError with 2s", err)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	srv := newTestServer(t, &Scenario{Rules: []Rule{{Match: "^[1-9]", Accrual: 50}}, Batch: true})
+	c := newTestClient(t, srv.URL)
+
+	bc, ok := c.(accrual.BatchClient)
+	if !ok {
+		t.Fatal("HTTP client does not implement BatchClient")
+	}
+	rs, err := bc.GetOrders(context.Background(), []string{"1", "0", "2"})
+	if err != nil {
+		t.Fatalf("GetOrders: %v", err)
+	}
+	if len(rs) != 2 || rs[0].Order != "1" || rs[1].Order != "2" {
+		t.Fatalf("GetOrders = %+v, want orders 1 and 2", rs)
+	}
+}
+
+func TestBatchDisabled(t *testing.T) {
+	srv := newTestServer(t, &Scenario{Rules: []Rule{{Match: ".*"}}})
+	bc := newTestClient(t, srv.URL).(accrual.BatchClient)
+
+	if _, err := bc.GetOrders(context.Background(), []string{"1"}); !errors.Is(err, accrual.ErrBatchUnsupported) {
+		t.Fatalf("GetOrders error %v, want ErrBatchUnsupported", err)
+	}
+}
+
+func TestRetryAfterSeconds(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want int
+	}{
+		{time.Second, 1},
+		{1500 * time.Millisecond, 2},
+		{100 * time.Millisecond, 1},
+		{10 * time.Second, 10},
+	}
+	for _, tt := range tests {
+		if got := retryAfterSeconds(tt.d); got != tt.want {
+			t.Errorf("retryAfterSeconds(%s) = %d, want %d", tt.d, got, tt.want)
+		}
+	}
+}
@@ -2,30 +2,102 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/Bekw/go-practicum-diploma/internal/accrual"
 	"github.com/Bekw/go-practicum-diploma/internal/config"
+	"github.com/Bekw/go-practicum-diploma/internal/events"
 	apphttp "github.com/Bekw/go-practicum-diploma/internal/http"
 	"github.com/Bekw/go-practicum-diploma/internal/storage"
+	"github.com/Bekw/go-practicum-diploma/internal/tracing"
+	"github.com/Bekw/go-practicum-diploma/internal/webhook"
 )
 
 func main() {
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run поднимает сервис и возвращает ошибку вместо выхода из процесса, чтобы
+// отложенные завершения трассировки и метрик успели выгрузить данные.
+func run() error {
 	cfg := config.Load()
 
 	if cfg.DatabaseURI == "" {
-		log.Fatal("DATABASE_URI (или флаг -d) не задан")
+		return errors.New("DATABASE_URI (или флаг -d) не задан")
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.TracesExporter)
+	if err != nil {
+		return fmt.Errorf("failed to init tracing: %w", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = shutdownTracing(ctx)
+	}()
+
+	shutdownMetrics, err := tracing.SetupMetrics(context.Background(), cfg.MetricsExporter)
+	if err != nil {
+		return fmt.Errorf("failed to init metrics: %w", err)
 	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = shutdownMetrics(ctx)
+	}()
 
-	store, err := storage.New(cfg.DatabaseURI)
+	store, err := storage.New(cfg.DatabaseURI, storage.Options{AuditKey: cfg.AuditHMACKey})
 	if err != nil {
-		log.Fatalf("failed to init storage: %v", err)
+		return fmt.Errorf("failed to init storage: %w", err)
 	}
 	defer store.Close()
 
+	grantAdmins(context.Background(), store, cfg.AdminLogins)
+
+	broker := events.NewBroker(store)
+	go broker.Run(context.Background())
+
+	go webhook.NewDispatcher(store).Run(context.Background())
+
+	go logAuditHead(context.Background(), store, time.Hour)
+
+	if cfg.IdempotencyTTL > 0 {
+		go purgeLoop(context.Background(), "idempotency keys", time.Hour, func(ctx context.Context) (int64, error) {
+			return store.PurgeIdempotencyKeys(ctx, cfg.IdempotencyTTL)
+		})
+	}
+	if cfg.AuditContextRetention > 0 {
+		go purgeLoop(context.Background(), "audit context", time.Hour, func(ctx context.Context) (int64, error) {
+			return store.PurgeAuditContext(ctx, cfg.AuditContextRetention)
+		})
+	}
+
+	var breaker *accrual.Breaker
 	if cfg.AccrualSystemAddr != "" {
-		p := accrual.NewProcessor(cfg.AccrualSystemAddr, store)
+		client, err := accrual.NewClient(cfg.AccrualSystemAddr)
+		if err != nil {
+			return fmt.Errorf("failed to init accrual client: %w", err)
+		}
+		breaker = accrual.NewBreaker(client, accrual.BreakerOptions{
+			FailureThreshold: cfg.AccrualBreakerFailures,
+			SuccessThreshold: cfg.AccrualBreakerSuccesses,
+			Cooldown:         cfg.AccrualBreakerCooldown,
+		})
+		p := accrual.NewProcessor(breaker, store, accrual.Options{
+			IdlePollInterval:  cfg.AccrualIdlePollInterval,
+			ReconcileWindow:   cfg.AccrualReconcileWindow,
+			ReconcileInterval: cfg.AccrualReconcileInterval,
+			BatchSize:         cfg.AccrualBatchSize,
+			StallAfter:        cfg.AccrualStallAfter,
+			StallAttempts:     cfg.AccrualStallAttempts,
+		})
 		go p.Run(context.Background())
 	} else {
 		log.Println("ACCRUAL_SYSTEM_ADDRESS не задан, обновление начислений отключено")
@@ -33,9 +105,95 @@ func main() {
 
 	log.Printf("starting on %s", cfg.RunAddress)
 
-	r := apphttp.NewRouter(store)
+	r := apphttp.NewRouter(store, broker, apphttp.Options{
+		AdminToken:            cfg.AdminToken,
+		AccrualCallbackSecret: cfg.AccrualCallbackSecret,
+		AccrualBreaker:        breaker,
+		IdempotencyTTL:        cfg.IdempotencyTTL,
+	})
 
 	if err := http.ListenAndServe(cfg.RunAddress, r); err != nil {
-		log.Fatalf("server stopped: %v", err)
+		return fmt.Errorf("server stopped: %w", err)
+	}
+	return nil
+}
+
+// grantAdmins назначает роль admin логинам из ADMIN_LOGINS. Повышаются
+// только обычные пользователи: роли support и merchant, назначенные
+// оператором, конфигурация не перетирает. Смена роли проходит через
+// ChangeUserRole и попадает в журнал аудита от имени системы.
+func grantAdmins(ctx context.Context, store *storage.Storage, logins string) {
+	for _, login := range strings.Split(logins, ",") {
+		if login = strings.TrimSpace(login); login == "" {
+			continue
+		}
+
+		u, err := store.GetUserByLogin(ctx, login)
+		if err != nil {
+			log.Printf("failed to grant admin role to %q: %v", login, err)
+			continue
+		}
+		switch {
+		case u.Deleted():
+			log.Printf("not granting admin role to %q: account is deleted", login)
+			continue
+		case u.Role == storage.RoleAdmin:
+			continue
+		case u.Role != storage.RoleUser && u.Role != "":
+			log.Printf("not granting admin role to %q: user has role %s", login, u.Role)
+			continue
+		}
+
+		if err := store.ChangeUserRole(ctx, u.ID, storage.RoleAdmin, 0); err != nil {
+			log.Printf("failed to grant admin role to %q: %v", login, err)
+			continue
+		}
+		log.Printf("granted admin role to %q (user %d)", login, u.ID)
+	}
+}
+
+// logAuditHead раз в interval пишет в лог последнюю запись цепочки аудита.
+// Лог хранится вне БД, и по нему GET /api/admin/audit/verify с head_id и
+// head_hash замечает цепочку, переписанную целиком или обрезанную.
+func logAuditHead(ctx context.Context, store *storage.Storage, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		head, ok, err := store.AuditHead(ctx)
+		switch {
+		case err != nil:
+			log.Printf("audit chain head: %v", err)
+		case ok:
+			log.Printf("audit chain head: id=%d hash=%s", head.ID, head.Hash)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// purgeLoop раз в interval удаляет устаревшие записи через purge.
+func purgeLoop(ctx context.Context, what string, interval time.Duration, purge func(context.Context) (int64, error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := purge(ctx)
+		switch {
+		case err != nil:
+			log.Printf("purge %s: %v", what, err)
+		case n > 0:
+			log.Printf("purged %d %s", n, what)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
 	}
 }
@@ -0,0 +1,216 @@
+package accrual
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+var meter = otel.Meter("github.com/Bekw/go-practicum-diploma/internal/accrual")
+
+// BreakerState — состояние автоматического выключателя.
+type BreakerState int
+
+const (
+	// BreakerClosed — запросы идут в систему начислений.
+	BreakerClosed BreakerState = iota
+	// BreakerHalfOpen — после паузы пропускается пробный запрос.
+	BreakerHalfOpen
+	// BreakerOpen — запросы отклоняются без обращения к системе начислений.
+	BreakerOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerHalfOpen:
+		return "half-open"
+	case BreakerOpen:
+		return "open"
+	default:
+		return fmt.Sprintf("BreakerState(%d)", int(s))
+	}
+}
+
+// ErrCircuitOpen — запрос отклонён выключателем. Оборачивает ErrUnavailable.
+var ErrCircuitOpen = fmt.Errorf("%w: circuit breaker is open", ErrUnavailable)
+
+// BreakerOptions — настройки Breaker. Нулевые поля заменяются значениями по
+// умолчанию.
+type BreakerOptions struct {
+	// FailureThreshold — сколько сбоев подряд размыкают цепь.
+	FailureThreshold int
+	// SuccessThreshold — сколько успешных пробных запросов подряд в
+	// half-open замыкают цепь.
+	SuccessThreshold int
+	// Cooldown — сколько цепь остаётся разомкнутой до пробного запроса.
+	Cooldown time.Duration
+}
+
+// Breaker — Client с автоматическим выключателем. Сбоем считается только
+// ErrUnavailable: незарегистрированный заказ и лимит запросов означают, что
+// система начислений отвечает.
+type Breaker struct {
+	client Client
+	opts   BreakerOptions
+
+	mu        sync.Mutex
+	state     BreakerState
+	failures  int
+	successes int
+	openedAt  time.Time
+	probing   bool
+
+	transitions metric.Int64Counter
+	rejected    metric.Int64Counter
+}
+
+func NewBreaker(client Client, opts BreakerOptions) *Breaker {
+	if opts.FailureThreshold <= 0 {
+		opts.FailureThreshold = 5
+	}
+	if opts.SuccessThreshold <= 0 {
+		opts.SuccessThreshold = 1
+	}
+	if opts.Cooldown <= 0 {
+		opts.Cooldown = 30 * time.Second
+	}
+
+	b := &Breaker{client: client, opts: opts}
+
+	// ошибки инструментов возможны только при некорректных именах
+	b.transitions, _ = meter.Int64Counter(
+		"accrual.circuit_breaker.transitions",
+		metric.WithDescription("Circuit breaker state changes by target state"),
+	)
+	b.rejected, _ = meter.Int64Counter(
+		"accrual.circuit_breaker.rejected",
+		metric.WithDescription("Accrual requests rejected while the circuit is open"),
+	)
+	_, _ = meter.Int64ObservableGauge(
+		"accrual.circuit_breaker.state",
+		metric.WithDescription("Circuit breaker state: 0 closed, 1 half-open, 2 open"),
+		metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+			o.Observe(int64(b.State()))
+			return nil
+		}),
+	)
+
+	return b
+}
+
+// State возвращает текущее состояние. Разомкнутая цепь, у которой истекла
+// пауза, считается half-open.
+func (b *Breaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerOpen && time.Since(b.openedAt) >= b.opts.Cooldown {
+		return BreakerHalfOpen
+	}
+	return b.state
+}
+
+func (b *Breaker) GetOrder(ctx context.Context, number string) (*Result, error) {
+	if err := b.allow(ctx); err != nil {
+		return nil, err
+	}
+	r, err := b.client.GetOrder(ctx, number)
+	b.record(ctx, err)
+	return r, err
+}
+
+// GetOrders делегирует пакетный запрос, если его поддерживает обёрнутый
+// клиент, иначе возвращает ErrBatchUnsupported.
+func (b *Breaker) GetOrders(ctx context.Context, numbers []string) ([]Result, error) {
+	bc, ok := b.client.(BatchClient)
+	if !ok {
+		return nil, ErrBatchUnsupported
+	}
+	if err := b.allow(ctx); err != nil {
+		return nil, err
+	}
+	rs, err := bc.GetOrders(ctx, numbers)
+	b.record(ctx, err)
+	return rs, err
+}
+
+func (b *Breaker) allow(ctx context.Context) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerOpen && time.Since(b.openedAt) >= b.opts.Cooldown {
+		b.setState(ctx, BreakerHalfOpen)
+	}
+
+	switch {
+	case b.state == BreakerOpen, b.state == BreakerHalfOpen && b.probing:
+		b.rejected.Add(ctx, 1)
+		return ErrCircuitOpen
+	case b.state == BreakerHalfOpen:
+		b.probing = true
+	}
+	return nil
+}
+
+func (b *Breaker) record(ctx context.Context, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err != nil && ctx.Err() != nil {
+		// отмена вызывающей стороной ничего не говорит о системе начислений
+		b.probing = false
+		return
+	}
+	failed := errors.Is(err, ErrUnavailable)
+
+	switch b.state {
+	case BreakerClosed:
+		if !failed {
+			b.failures = 0
+			return
+		}
+		b.failures++
+		if b.failures >= b.opts.FailureThreshold {
+			log.Printf("accrual: %d consecutive failures, last: %v", b.failures, err)
+			b.open(ctx)
+		}
+
+	case BreakerHalfOpen:
+		b.probing = false
+		if failed {
+			log.Printf("accrual: probe request failed: %v", err)
+			b.open(ctx)
+			return
+		}
+		b.successes++
+		if b.successes >= b.opts.SuccessThreshold {
+			b.failures = 0
+			b.setState(ctx, BreakerClosed)
+		}
+	}
+}
+
+func (b *Breaker) open(ctx context.Context) {
+	b.openedAt = time.Now()
+	b.setState(ctx, BreakerOpen)
+}
+
+func (b *Breaker) setState(ctx context.Context, s BreakerState) {
+	if b.state == s {
+		return
+	}
+	log.Printf("accrual: circuit breaker %s -> %s", b.state, s)
+	b.state = s
+	b.successes = 0
+	b.probing = false
+	b.transitions.Add(ctx, 1, metric.WithAttributes(attribute.String("state", s.String())))
+}
@@ -0,0 +1,213 @@
+package accrual
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testCooldown = 20 * time.Millisecond
+
+// fakeClient отвечает ошибкой из errs по очереди (nil — успех). Если задан
+// block, вызов ждёт значения из него.
+type fakeClient struct {
+	mu    sync.Mutex
+	errs  []error
+	calls int
+	block chan struct{}
+}
+
+func (c *fakeClient) GetOrder(ctx context.Context, number string) (*Result, error) {
+	c.mu.Lock()
+	var err error
+	if c.calls < len(c.errs) {
+		err = c.errs[c.calls]
+	}
+	c.calls++
+	block := c.block
+	c.mu.Unlock()
+
+	if block != nil {
+		select {
+		case <-block:
+		case <-ctx.Done():
+			return nil, unavailable(ctx.Err())
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Result{Order: number, Status: "PROCESSED"}, nil
+}
+
+func (c *fakeClient) Calls() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.calls
+}
+
+var errDown = unavailable(errors.New("connection refused"))
+
+func TestBreakerTransitions(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  BreakerOptions
+		errs  []error
+		steps []BreakerState // состояние после каждого вызова
+		sleep map[int]bool   // перед вызовом i выждать паузу
+	}{
+		{
+			name:  "stays closed below threshold",
+			opts:  BreakerOptions{FailureThreshold: 3},
+			errs:  []error{errDown, errDown, nil, errDown, errDown},
+			steps: []BreakerState{BreakerClosed, BreakerClosed, BreakerClosed, BreakerClosed, BreakerClosed},
+		},
+		{
+			name:  "not registered and rate limit are not failures",
+			opts:  BreakerOptions{FailureThreshold: 1},
+			errs:  []error{ErrNotRegistered, &RateLimitError{RetryAfter: time.Second}},
+			steps: []BreakerState{BreakerClosed, BreakerClosed},
+		},
+		{
+			name:  "closed to open to half-open to closed",
+			opts:  BreakerOptions{FailureThreshold: 2},
+			errs:  []error{errDown, errDown, nil},
+			steps: []BreakerState{BreakerClosed, BreakerOpen, BreakerClosed},
+			sleep: map[int]bool{2: true},
+		},
+		{
+			name:  "failed probe reopens",
+			opts:  BreakerOptions{FailureThreshold: 1},
+			errs:  []error{errDown, errDown, nil},
+			steps: []BreakerState{BreakerOpen, BreakerOpen, BreakerClosed},
+			sleep: map[int]bool{1: true, 2: true},
+		},
+		{
+			name:  "success threshold needs several probes",
+			opts:  BreakerOptions{FailureThreshold: 1, SuccessThreshold: 2},
+			errs:  []error{errDown, nil, nil},
+			steps: []BreakerState{BreakerOpen, BreakerHalfOpen, BreakerClosed},
+			sleep: map[int]bool{1: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.Cooldown = testCooldown
+			b := NewBreaker(&fakeClient{errs: tt.errs}, tt.opts)
+
+			for i, want := range tt.steps {
+				if tt.sleep[i] {
+					time.Sleep(testCooldown)
+					if s := b.State(); s != BreakerHalfOpen {
+						t.Fatalf("step %d: state after cooldown %s, want half-open", i, s)
+					}
+				}
+				_, _ = b.GetOrder(context.Background(), "1")
+				if s := b.State(); s != want {
+					t.Fatalf("step %d: state %s, want %s", i, s, want)
+				}
+			}
+		})
+	}
+}
+
+func TestBreakerRejectsWhileOpen(t *testing.T) {
+	fc := &fakeClient{errs: []error{errDown}}
+	b := NewBreaker(fc, BreakerOptions{FailureThreshold: 1, Cooldown: time.Hour})
+
+	_, _ = b.GetOrder(context.Background(), "1")
+	_, err := b.GetOrder(context.Background(), "1")
+	if !errors.Is(err, ErrCircuitOpen) || !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("err = %v, want ErrCircuitOpen wrapping ErrUnavailable", err)
+	}
+	if fc.Calls() != 1 {
+		t.Fatalf("client called %d times, want 1", fc.Calls())
+	}
+}
+
+func TestBreakerSingleProbe(t *testing.T) {
+	fc := &fakeClient{errs: []error{errDown}}
+	b := NewBreaker(fc, BreakerOptions{FailureThreshold: 1, Cooldown: testCooldown})
+
+	_, _ = b.GetOrder(context.Background(), "1")
+	time.Sleep(testCooldown)
+
+	fc.mu.Lock()
+	fc.block = make(chan struct{})
+	fc.mu.Unlock()
+
+	done := make(chan error)
+	go func() {
+		_, err := b.GetOrder(context.Background(), "1")
+		done <- err
+	}()
+
+	// ждём, пока пробный запрос дойдёт до клиента
+	for fc.Calls() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	if _, err := b.GetOrder(context.Background(), "1"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("second request during probe: err = %v, want ErrCircuitOpen", err)
+	}
+	if fc.Calls() != 2 {
+		t.Fatalf("client called %d times, want 2", fc.Calls())
+	}
+
+	close(fc.block)
+	if err := <-done; err != nil {
+		t.Fatalf("probe: %v", err)
+	}
+	if s := b.State(); s != BreakerClosed {
+		t.Fatalf("state %s, want closed", s)
+	}
+}
+
+func TestBreakerIgnoresCancellation(t *testing.T) {
+	t.Run("closed", func(t *testing.T) {
+		fc := &fakeClient{block: make(chan struct{})}
+		b := NewBreaker(fc, BreakerOptions{FailureThreshold: 1, Cooldown: testCooldown})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, err := b.GetOrder(ctx, "1"); err == nil {
+			t.Fatal("expected error from cancelled call")
+		}
+		if s := b.State(); s != BreakerClosed {
+			t.Fatalf("state %s, want closed", s)
+		}
+	})
+
+	t.Run("half-open", func(t *testing.T) {
+		fc := &fakeClient{errs: []error{errDown}}
+		b := NewBreaker(fc, BreakerOptions{FailureThreshold: 1, Cooldown: testCooldown})
+
+		_, _ = b.GetOrder(context.Background(), "1")
+		time.Sleep(testCooldown)
+
+		fc.mu.Lock()
+		fc.block = make(chan struct{})
+		fc.mu.Unlock()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, _ = b.GetOrder(ctx, "1")
+		if s := b.State(); s != BreakerHalfOpen {
+			t.Fatalf("state after cancelled probe %s, want half-open", s)
+		}
+
+		// отменённый пробный запрос освобождает место для следующего
+		fc.mu.Lock()
+		fc.block = nil
+		fc.mu.Unlock()
+		if _, err := b.GetOrder(context.Background(), "1"); err != nil {
+			t.Fatalf("next probe: %v", err)
+		}
+		if s := b.State(); s != BreakerClosed {
+			t.Fatalf("state %s, want closed", s)
+		}
+	})
+}
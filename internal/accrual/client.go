@@ -0,0 +1,77 @@
+package accrual
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client получает расчёт по заказу из системы начислений независимо от
+// транспорта (HTTP или gRPC, см. NewClient). Незарегистрированный заказ —
+// ErrNotRegistered, превышение лимита — *RateLimitError, прочие сбои —
+// ErrUnavailable.
+type Client interface {
+	GetOrder(ctx context.Context, number string) (*Result, error)
+}
+
+// BatchClient — необязательное расширение Client: расчёты по нескольким
+// заказам за один вызов. Незарегистрированные заказы в ответ не попадают.
+type BatchClient interface {
+	GetOrders(ctx context.Context, numbers []string) ([]Result, error)
+}
+
+var (
+	// ErrBatchUnsupported — система начислений не поддерживает пакетный
+	// запрос; нужно запрашивать заказы по одному.
+	ErrBatchUnsupported = errors.New("accrual system does not support batch lookups")
+	// ErrNotRegistered — заказ не зарегистрирован в системе начислений.
+	ErrNotRegistered = errors.New("order is not registered in accrual system")
+	// ErrRateLimited — превышен лимит запросов, см. RateLimitError.
+	ErrRateLimited = errors.New("accrual system rate limit exceeded")
+	// ErrUnavailable — система начислений недоступна или ответила ошибкой.
+	ErrUnavailable = errors.New("accrual system unavailable")
+)
+
+// RateLimitError сообщает, через сколько можно повторить запрос.
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%v, retry after %s", ErrRateLimited, e.RetryAfter)
+}
+
+func (e *RateLimitError) Unwrap() error {
+	return ErrRateLimited
+}
+
+func unavailable(err error) error {
+	return fmt.Errorf("%w: %v", ErrUnavailable, err)
+}
+
+// NewClient выбирает транспорт по схеме адреса: grpc:// и grpcs:// — gRPC
+// (grpcs с TLS), http://, https:// или адрес без схемы — HTTP.
+func NewClient(addr string) (Client, error) {
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, fmt.Errorf("parse accrual address: %w", err)
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		return NewHTTPClient(addr), nil
+	case "grpc":
+		return NewGRPCClient(u.Host, false)
+	case "grpcs":
+		return NewGRPCClient(u.Host, true)
+	default:
+		return nil, fmt.Errorf("unsupported accrual address scheme %q", u.Scheme)
+	}
+}
@@ -0,0 +1,92 @@
+package accrual
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+
+	accrualv1 "github.com/Bekw/go-practicum-diploma/api/accrual/v1"
+)
+
+// GRPCClient — клиент gRPC-сервиса системы начислений
+// (api/accrual/v1/accrual.proto), реализация Client.
+type GRPCClient struct {
+	conn    *grpc.ClientConn
+	client  accrualv1.AccrualServiceClient
+	timeout time.Duration
+}
+
+func NewGRPCClient(target string, useTLS bool) (*GRPCClient, error) {
+	creds := insecure.NewCredentials()
+	if useTLS {
+		creds = credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12})
+	}
+	return newGRPCClient(target, grpc.WithTransportCredentials(creds))
+}
+
+func newGRPCClient(target string, opts ...grpc.DialOption) (*GRPCClient, error) {
+	// otelgrpc прокидывает traceparent в метаданные вызова
+	opts = append(opts, grpc.WithStatsHandler(otelgrpc.NewClientHandler()))
+	conn, err := grpc.NewClient(target, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("create grpc client: %w", err)
+	}
+
+	return &GRPCClient{
+		conn:    conn,
+		client:  accrualv1.NewAccrualServiceClient(conn),
+		timeout: 5 * time.Second,
+	}, nil
+}
+
+func (c *GRPCClient) Close() error {
+	return c.conn.Close()
+}
+
+func (c *GRPCClient) GetOrder(ctx context.Context, number string) (*Result, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	resp, err := c.client.GetOrder(ctx, &accrualv1.GetOrderRequest{Order: number})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return &Result{
+		Order:   resp.GetOrder(),
+		Status:  resp.GetStatus(),
+		Accrual: resp.Accrual,
+	}, nil
+}
+
+// grpcError переводит статус вызова в ошибки Client.
+func grpcError(err error) error {
+	st, ok := status.FromError(err)
+	if !ok {
+		return unavailable(err)
+	}
+
+	switch st.Code() {
+	case codes.NotFound:
+		return ErrNotRegistered
+	case codes.ResourceExhausted:
+		rl := &RateLimitError{RetryAfter: time.Minute}
+		for _, d := range st.Details() {
+			if ri, ok := d.(*errdetails.RetryInfo); ok && ri.GetRetryDelay() != nil {
+				rl.RetryAfter = ri.GetRetryDelay().AsDuration()
+			}
+		}
+		return rl
+	default:
+		return unavailable(err)
+	}
+}
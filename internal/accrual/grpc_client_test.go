@@ -0,0 +1,147 @@
+package accrual
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/bufbuild/protocompile"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	accrualv1 "github.com/Bekw/go-practicum-diploma/api/accrual/v1"
+)
+
+// Сгенерированный код должен соответствовать accrual.proto: тест ловит
+// правку файла без go generate.
+func TestGeneratedCodeMatchesProto(t *testing.T) {
+	compiler := protocompile.Compiler{
+		Resolver: &protocompile.SourceResolver{ImportPaths: []string{"../../api"}},
+	}
+	files, err := compiler.Compile(context.Background(), "accrual/v1/accrual.proto")
+	if err != nil {
+		t.Fatalf("compile accrual.proto: %v", err)
+	}
+
+	want := protodesc.ToFileDescriptorProto(files[0])
+	want.SourceCodeInfo = nil
+	got := protodesc.ToFileDescriptorProto(accrualv1.File_accrual_v1_accrual_proto)
+	got.SourceCodeInfo = nil
+
+	if !proto.Equal(got, want) {
+		t.Fatalf("generated code is out of date with accrual.proto, run go generate ./api/...\ngot:\n%s\nwant:\n%s",
+			prototext.Format(got), prototext.Format(want))
+	}
+}
+
+// testAccrualServer отвечает по номеру заказа так, как это делает система
+// начислений.
+type testAccrualServer struct {
+	accrualv1.UnimplementedAccrualServiceServer
+}
+
+func (testAccrualServer) GetOrder(_ context.Context, req *accrualv1.GetOrderRequest) (*accrualv1.GetOrderResponse, error) {
+	switch req.GetOrder() {
+	case "processed":
+		return &accrualv1.GetOrderResponse{Order: req.GetOrder(), Status: "PROCESSED", Accrual: proto.Float64(729.98)}, nil
+	case "processing":
+		return &accrualv1.GetOrderResponse{Order: req.GetOrder(), Status: "PROCESSING"}, nil
+	case "not-found":
+		return nil, status.Error(codes.NotFound, "order is not registered")
+	case "limited":
+		st, err := status.New(codes.ResourceExhausted, "too many requests").
+			WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(7 * time.Second)})
+		if err != nil {
+			return nil, err
+		}
+		return nil, st.Err()
+	case "limited-no-delay":
+		return nil, status.Error(codes.ResourceExhausted, "too many requests")
+	default:
+		return nil, status.Error(codes.Unavailable, "try later")
+	}
+}
+
+func newBufconnClient(t *testing.T) *GRPCClient {
+	t.Helper()
+
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	accrualv1.RegisterAccrualServiceServer(srv, testAccrualServer{})
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(srv.Stop)
+
+	c, err := newGRPCClient(
+		"passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("newGRPCClient: %v", err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+	return c
+}
+
+func TestGRPCClientGetOrder(t *testing.T) {
+	c := newBufconnClient(t)
+	ctx := context.Background()
+
+	r, err := c.GetOrder(ctx, "processed")
+	if err != nil {
+		t.Fatalf("GetOrder(processed): %v", err)
+	}
+	if r.Order != "processed" || r.Status != "PROCESSED" || r.Accrual == nil || *r.Accrual != 729.98 {
+		t.Fatalf("GetOrder(processed) = %+v", r)
+	}
+
+	r, err = c.GetOrder(ctx, "processing")
+	if err != nil {
+		t.Fatalf("GetOrder(processing): %v", err)
+	}
+	if r.Status != "PROCESSING" || r.Accrual != nil {
+		t.Fatalf("GetOrder(processing) = %+v, want no accrual", r)
+	}
+}
+
+func TestGRPCClientErrors(t *testing.T) {
+	c := newBufconnClient(t)
+
+	tests := []struct {
+		number     string
+		want       error
+		retryAfter time.Duration
+	}{
+		{"not-found", ErrNotRegistered, 0},
+		{"limited", ErrRateLimited, 7 * time.Second},
+		{"limited-no-delay", ErrRateLimited, time.Minute},
+		{"down", ErrUnavailable, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.number, func(t *testing.T) {
+			_, err := c.GetOrder(context.Background(), tt.number)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+			var rl *RateLimitError
+			if errors.As(err, &rl) != (tt.retryAfter > 0) {
+				t.Fatalf("err = %v, RateLimitError expected: %v", err, tt.retryAfter > 0)
+			}
+			if rl != nil && rl.RetryAfter != tt.retryAfter {
+				t.Errorf("RetryAfter = %s, want %s", rl.RetryAfter, tt.retryAfter)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package accrual
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// HTTPClient — клиент REST API системы начислений (GET /api/orders/{number}).
+type HTTPClient struct {
+	baseURL string
+	client  *http.Client
+}
+
+func NewHTTPClient(baseURL string) *HTTPClient {
+	return &HTTPClient{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		client: &http.Client{
+			Timeout: 5 * time.Second,
+			// otelhttp прокидывает traceparent в систему начислений
+			Transport: otelhttp.NewTransport(http.DefaultTransport),
+		},
+	}
+}
+
+func (c *HTTPClient) GetOrder(ctx context.Context, number string) (*Result, error) {
+	u := fmt.Sprintf("%s/api/orders/%s", c.baseURL, url.PathEscape(number))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, unavailable(err)
+	}
+	defer resp.Body.Close()
+
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int("accrual.response_status", resp.StatusCode))
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		var r Result
+		if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+			return nil, unavailable(fmt.Errorf("decode response: %w", err))
+		}
+		return &r, nil
+
+	case http.StatusNoContent:
+		return nil, ErrNotRegistered
+
+	default:
+		return nil, statusError(resp)
+	}
+}
+
+// GetOrders запрашивает расчёты пачкой: POST /api/orders/batch с массивом
+// номеров в теле.
+func (c *HTTPClient) GetOrders(ctx context.Context, numbers []string) ([]Result, error) {
+	body, err := json.Marshal(numbers)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/api/orders/batch", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, unavailable(err)
+	}
+	defer resp.Body.Close()
+
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int("accrual.response_status", resp.StatusCode))
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		var rs []Result
+		if err := json.NewDecoder(resp.Body).Decode(&rs); err != nil {
+			return nil, unavailable(fmt.Errorf("decode response: %w", err))
+		}
+		return rs, nil
+
+	case http.StatusNoContent:
+		return nil, nil
+
+	case http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		return nil, ErrBatchUnsupported
+
+	default:
+		return nil, statusError(resp)
+	}
+}
+
+// statusError переводит неуспешный ответ в ошибки Client.
+func statusError(resp *http.Response) error {
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		rl := &RateLimitError{RetryAfter: time.Minute}
+		if sec, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			rl.RetryAfter = time.Duration(sec) * time.Second
+		}
+		return rl
+	default:
+		return unavailable(fmt.Errorf("unexpected status %d", resp.StatusCode))
+	}
+}
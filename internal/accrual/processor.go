@@ -2,35 +2,98 @@ package accrual
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"net/http"
-	"strconv"
-	"strings"
+	"log"
 	"time"
 
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+
 	"github.com/Bekw/go-practicum-diploma/internal/storage"
 )
 
+var tracer = otel.Tracer("github.com/Bekw/go-practicum-diploma/internal/accrual")
+
+// Options — необязательные настройки Processor. Нулевые поля заменяются
+// значениями по умолчанию.
+type Options struct {
+	// IdlePollInterval — как часто опрашивать БД, когда очередь пуста и
+	// уведомлений о новых заказах нет.
+	IdlePollInterval time.Duration
+	// ReconcileWindow — как долго после обработки заказ перепроверяется
+	// на корректировки начисления. 0 отключает сверку.
+	ReconcileWindow time.Duration
+	// ReconcileInterval — период прохода сверки.
+	ReconcileInterval time.Duration
+	// BatchSize — сколько заказов берётся из очереди за проход. Если клиент
+	// реализует BatchClient, пачка запрашивается одним вызовом.
+	BatchSize int
+	// StallAfter и StallAttempts — сколько заказ может ждать окончательного
+	// ответа и сколько раз его можно опросить, прежде чем он перейдёт в
+	// STALLED. 0 отключает соответствующий предел.
+	StallAfter    time.Duration
+	StallAttempts int
+}
+
+// busyPollInterval — пауза между проходами, пока в очереди есть заказы
+// (заказы в PROCESSING ждут ответа системы начислений).
+const busyPollInterval = time.Second
+
 type Processor struct {
-	baseURL string
-	store   *storage.Storage
-	client  *http.Client
+	client Client
+	store  *storage.Storage
+	opts   Options
+	wake   chan struct{}
+
+	// noBatch выставляется, если система начислений ответила, что пакетных
+	// запросов не поддерживает. Используется только из Run.
+	noBatch bool
 }
 
-func NewProcessor(baseURL string, store *storage.Storage) *Processor {
+func NewProcessor(client Client, store *storage.Storage, opts Options) *Processor {
+	if opts.IdlePollInterval <= 0 {
+		opts.IdlePollInterval = 30 * time.Second
+	}
+	if opts.ReconcileInterval <= 0 {
+		opts.ReconcileInterval = time.Minute
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 10
+	}
+
 	return &Processor{
-		baseURL: strings.TrimRight(baseURL, "/"),
-		store:   store,
-		client: &http.Client{
-			Timeout: 5 * time.Second,
-		},
+		client: client,
+		store:  store,
+		opts:   opts,
+		wake:   make(chan struct{}, 1),
 	}
 }
 
+// Wake будит обработчик, не дожидаясь очередного опроса. Не блокируется.
+func (p *Processor) Wake() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run обрабатывает очередь, пока не отменён ctx. Пока есть заказы в работе,
+// проходы идут раз в busyPollInterval; на пустой очереди обработчик спит до
+// уведомления о новом заказе (NOTIFY или Wake) либо до IdlePollInterval.
 func (p *Processor) Run(ctx context.Context) {
-	ticker := time.NewTicker(1 * time.Second)
-	defer ticker.Stop()
+	go p.listenNewOrders(ctx)
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	var reconcileC <-chan time.Time
+	if p.opts.ReconcileWindow > 0 {
+		rt := time.NewTicker(p.opts.ReconcileInterval)
+		defer rt.Stop()
+		reconcileC = rt.C
+	}
 
 	var nextAllowed time.Time
 
@@ -38,93 +101,271 @@ func (p *Processor) Run(ctx context.Context) {
 		select {
 		case <-ctx.Done():
 			return
-		case <-ticker.C:
+		case <-p.wake:
+			timer.Reset(p.untilAllowed(nextAllowed))
+		case <-timer.C:
+			if wait := p.untilAllowed(nextAllowed); wait > 0 {
+				timer.Reset(wait)
+				continue
+			}
+			n, err := p.processBatch(ctx, &nextAllowed)
+			next := p.opts.IdlePollInterval
+			if n > 0 || err != nil {
+				next = busyPollInterval
+			}
+			timer.Reset(max(next, p.untilAllowed(nextAllowed)))
+		case <-reconcileC:
 			if !nextAllowed.IsZero() && time.Now().Before(nextAllowed) {
 				continue
 			}
-			_ = p.processBatch(ctx, &nextAllowed)
+			_ = p.reconcileBatch(ctx, &nextAllowed)
+		}
+	}
+}
+
+func (p *Processor) untilAllowed(nextAllowed time.Time) time.Duration {
+	if nextAllowed.IsZero() {
+		return 0
+	}
+	return max(time.Until(nextAllowed), 0)
+}
+
+// listenNewOrders переводит NOTIFY о новых заказах в Wake. При обрыве
+// соединения переподключается; пока его нет, работает опрос по таймеру.
+func (p *Processor) listenNewOrders(ctx context.Context) {
+	backoff := time.Second
+	for {
+		err := p.store.Listen(ctx, storage.NewOrdersChannel, func(string) {
+			backoff = time.Second
+			p.Wake()
+		})
+		if ctx.Err() != nil {
+			return
 		}
+		log.Printf("accrual: new orders listener stopped: %v, reconnecting in %s", err, backoff)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, 30*time.Second)
 	}
 }
 
-func (p *Processor) processBatch(ctx context.Context, nextAllowed *time.Time) error {
-	const batchSize = 10
+// processBatch обрабатывает очередную пачку и возвращает её размер.
+func (p *Processor) processBatch(ctx context.Context, nextAllowed *time.Time) (int, error) {
+	stalled, err := p.store.StallOrders(ctx, p.opts.StallAfter, p.opts.StallAttempts)
+	for _, o := range stalled {
+		log.Printf("accrual: order %s stalled, uploaded at %s", o.Number, o.UploadedAt.Format(time.RFC3339))
+	}
+	if err != nil {
+		return 0, err
+	}
 
-	orders, err := p.store.ListOrdersForAccrual(ctx, batchSize)
+	orders, err := p.store.ListOrdersForAccrual(ctx, p.opts.BatchSize)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	if len(orders) == 0 {
-		return nil
+
+	results, err := p.fetchResults(ctx, orders, nextAllowed)
+
+	answered := make([]int64, 0, len(results))
+	for _, o := range orders {
+		if _, ok := results[o.Number]; ok {
+			answered = append(answered, o.ID)
+		}
+	}
+	if err := p.store.RecordAccrualAttempts(ctx, answered); err != nil {
+		return len(orders), err
 	}
 
 	for _, o := range orders {
-		if err := p.processOrder(ctx, &o, nextAllowed); err != nil {
+		r := results[o.Number]
+		if r == nil {
+			continue
+		}
+		if err := p.processOrder(ctx, &o, r); err != nil {
 			if ctx.Err() != nil {
-				return ctx.Err()
+				return len(orders), ctx.Err()
 			}
 		}
 	}
 
-	return nil
+	return len(orders), err
 }
 
-type accrualResponse struct {
+// Result — ответ системы начислений по одному заказу. Тот же формат
+// принимается и в push-уведомлениях от системы начислений.
+type Result struct {
 	Order   string   `json:"order"`
 	Status  string   `json:"status"`
 	Accrual *float64 `json:"accrual,omitempty"`
 }
 
-func (p *Processor) processOrder(ctx context.Context, o *storage.Order, nextAllowed *time.Time) error {
-	url := fmt.Sprintf("%s/api/orders/%s", p.baseURL, o.Number)
+var ErrUnknownStatus = errors.New("unknown accrual status")
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
-	if err != nil {
-		return err
+// ResultStore сохраняет результаты начислений (см. ApplyResult).
+type ResultStore interface {
+	UpdateOrderAccrual(ctx context.Context, number, status string, accrual *float64) error
+}
+
+// ApplyResult переводит статус системы начислений в статус заказа и
+// сохраняет его. Используется и опросом, и приёмом push-уведомлений.
+func ApplyResult(ctx context.Context, store ResultStore, r Result) error {
+	status, ok := mapStatus(r.Status)
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownStatus, r.Status)
 	}
+	return store.UpdateOrderAccrual(ctx, r.Order, status, r.Accrual)
+}
 
-	resp, err := p.client.Do(req)
-	if err != nil {
-		return err
+func (p *Processor) processOrder(ctx context.Context, o *storage.Order, ar *Result) (err error) {
+	ctx, span := tracer.Start(ctx, "accrual.processOrder")
+	span.SetAttributes(
+		attribute.String("order.number", o.Number),
+		attribute.String("order.status", o.Status),
+	)
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	err = ApplyResult(ctx, p.store, *ar)
+	if errors.Is(err, ErrUnknownStatus) {
+		return nil
+	}
+	if errors.Is(err, storage.ErrIllegalTransition) || errors.Is(err, storage.ErrUnexpectedAccrual) {
+		log.Printf("accrual result for order %s rejected: %v", o.Number, err)
 	}
-	defer resp.Body.Close()
+	return err
+}
+
+// fetchResults запрашивает расчёты по пачке заказов: одним вызовом, если
+// клиент реализует BatchClient, иначе по одному. В ответе только заказы,
+// на которые система начислений ответила; nil — заказ не зарегистрирован.
+// После ответа о лимите запросов сдвигается nextAllowed, опрос пачки
+// прекращается и возвращается уже полученное.
+func (p *Processor) fetchResults(ctx context.Context, orders []storage.Order, nextAllowed *time.Time) (map[string]*Result, error) {
+	res := make(map[string]*Result, len(orders))
 
-	switch resp.StatusCode {
-	case http.StatusOK:
-		var ar accrualResponse
-		if err := json.NewDecoder(resp.Body).Decode(&ar); err != nil {
-			return err
+	if bc, ok := p.client.(BatchClient); ok && !p.noBatch && len(orders) > 1 {
+		numbers := make([]string, len(orders))
+		for i, o := range orders {
+			numbers[i] = o.Number
 		}
 
-		var newStatus string
-		switch ar.Status {
-		case "REGISTERED", "PROCESSING":
-			newStatus = "PROCESSING"
-		case "INVALID":
-			newStatus = "INVALID"
-		case "PROCESSED":
-			newStatus = "PROCESSED"
+		rs, err := bc.GetOrders(ctx, numbers)
+		var rl *RateLimitError
+		switch {
+		case err == nil:
+			for _, n := range numbers {
+				res[n] = nil
+			}
+			for i := range rs {
+				if _, ok := res[rs[i].Order]; ok {
+					res[rs[i].Order] = &rs[i]
+				}
+			}
+			return res, nil
+		case errors.Is(err, ErrBatchUnsupported):
+			log.Printf("accrual: %v, falling back to per-order requests", err)
+			p.noBatch = true
+		case errors.As(err, &rl):
+			*nextAllowed = time.Now().Add(rl.RetryAfter)
+			return res, nil
 		default:
-			newStatus = o.Status
+			return res, err
 		}
+	}
 
-		return p.store.UpdateOrderAccrual(ctx, o.Number, newStatus, ar.Accrual)
+	for _, o := range orders {
+		r, err := p.client.GetOrder(ctx, o.Number)
+		var rl *RateLimitError
+		switch {
+		case err == nil:
+			r.Order = o.Number
+			res[o.Number] = r
+		case errors.Is(err, ErrNotRegistered):
+			res[o.Number] = nil
+		case errors.As(err, &rl):
+			*nextAllowed = time.Now().Add(rl.RetryAfter)
+			return res, nil
+		case ctx.Err() != nil:
+			return res, ctx.Err()
+		case errors.Is(err, ErrCircuitOpen):
+			return res, err
+		}
+	}
+	return res, nil
+}
 
-	case http.StatusNoContent:
-		return nil
+// mapStatus переводит статус системы начислений в статус заказа.
+func mapStatus(accrualStatus string) (string, bool) {
+	switch accrualStatus {
+	case "REGISTERED", "PROCESSING":
+		return storage.OrderStatusProcessing, true
+	case "INVALID":
+		return storage.OrderStatusInvalid, true
+	case "PROCESSED":
+		return storage.OrderStatusProcessed, true
+	default:
+		return "", false
+	}
+}
+
+func (p *Processor) reconcileBatch(ctx context.Context, nextAllowed *time.Time) error {
+	orders, err := p.store.ListOrdersForReconciliation(ctx, p.opts.ReconcileWindow, p.opts.BatchSize)
+	if err != nil {
+		return err
+	}
 
-	case http.StatusTooManyRequests:
-		if ra := resp.Header.Get("Retry-After"); ra != "" {
-			if sec, err := strconv.Atoi(ra); err == nil {
-				*nextAllowed = time.Now().Add(time.Duration(sec) * time.Second)
+	results, err := p.fetchResults(ctx, orders, nextAllowed)
+	for _, o := range orders {
+		r := results[o.Number]
+		if r == nil {
+			continue
+		}
+		if err := p.reconcileOrder(ctx, &o, r); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
 			}
 		}
-		return nil
+	}
 
-	case http.StatusInternalServerError:
-		return nil
+	return err
+}
 
-	default:
+// reconcileOrder перепроверяет уже обработанный заказ. Учитываются только
+// окончательные ответы (PROCESSED/INVALID); расхождение записывается
+// корректировкой, исходное начисление не меняется.
+func (p *Processor) reconcileOrder(ctx context.Context, o *storage.Order, ar *Result) (err error) {
+	ctx, span := tracer.Start(ctx, "accrual.reconcileOrder")
+	span.SetAttributes(attribute.String("order.number", o.Number))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	status, _ := mapStatus(ar.Status)
+	if status != storage.OrderStatusProcessed && status != storage.OrderStatusInvalid {
 		return nil
 	}
+
+	adj, err := p.store.ReconcileOrderAccrual(ctx, o.ID, status, ar.Accrual)
+	if err != nil {
+		return err
+	}
+	if adj != nil {
+		span.SetAttributes(attribute.Float64("accrual.adjustment_delta", adj.Delta))
+		log.Printf("accrual adjustment for order %s: %s %.2f -> %s %.2f (delta %.2f)",
+			o.Number, adj.PreviousStatus, adj.PreviousAccrual, adj.NewStatus, adj.NewAccrual, adj.Delta)
+	}
+	return nil
 }
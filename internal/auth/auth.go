@@ -18,8 +18,24 @@ func CookieName() string {
 	return cookieName
 }
 
-func GenerateToken(userID int64) (string, error) {
-	data := strconv.FormatInt(userID, 10)
+// Claims — содержимое токена.
+type Claims struct {
+	UserID int64
+	// Role — роль на момент выдачи токена. У токенов, выданных до появления
+	// ролей, пустая.
+	Role string
+	// Version — версия токенов пользователя на момент выдачи. У токенов,
+	// выданных до появления версий, 0.
+	Version int
+}
+
+// GenerateToken подписывает "id:role:version". Роль не может содержать ":"
+// и ".".
+func GenerateToken(userID int64, role string, version int) (string, error) {
+	if strings.ContainsAny(role, ":.") {
+		return "", fmt.Errorf("bad role %q", role)
+	}
+	data := strconv.FormatInt(userID, 10) + ":" + role + ":" + strconv.Itoa(version)
 
 	mac := hmac.New(sha256.New, secret)
 	if _, err := mac.Write([]byte(data)); err != nil {
@@ -31,37 +47,46 @@ func GenerateToken(userID int64) (string, error) {
 	return base64.URLEncoding.EncodeToString([]byte(token)), nil
 }
 
-func ParseToken(token string) (int64, error) {
+func ParseToken(token string) (Claims, error) {
 	raw, err := base64.URLEncoding.DecodeString(token)
 	if err != nil {
-		return 0, fmt.Errorf("decode token: %w", err)
+		return Claims{}, fmt.Errorf("decode token: %w", err)
 	}
 
 	parts := strings.SplitN(string(raw), ".", 2)
 	if len(parts) != 2 {
-		return 0, fmt.Errorf("bad token format")
+		return Claims{}, fmt.Errorf("bad token format")
 	}
 
 	data, sigHex := parts[0], parts[1]
 
 	mac := hmac.New(sha256.New, secret)
 	if _, err := mac.Write([]byte(data)); err != nil {
-		return 0, fmt.Errorf("mac write: %w", err)
+		return Claims{}, fmt.Errorf("mac write: %w", err)
 	}
 	expected := mac.Sum(nil)
 
 	sig, err := hex.DecodeString(sigHex)
 	if err != nil {
-		return 0, fmt.Errorf("decode sig: %w", err)
+		return Claims{}, fmt.Errorf("decode sig: %w", err)
 	}
 
 	if !hmac.Equal(expected, sig) {
-		return 0, fmt.Errorf("bad token signature")
+		return Claims{}, fmt.Errorf("bad token signature")
 	}
 
-	id, err := strconv.ParseInt(data, 10, 64)
+	idStr, rest, _ := strings.Cut(data, ":")
+	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
-		return 0, fmt.Errorf("parse id: %w", err)
+		return Claims{}, fmt.Errorf("parse id: %w", err)
+	}
+	role, versionStr, _ := strings.Cut(rest, ":")
+
+	var version int
+	if versionStr != "" {
+		if version, err = strconv.Atoi(versionStr); err != nil {
+			return Claims{}, fmt.Errorf("parse version: %w", err)
+		}
 	}
-	return id, nil
+	return Claims{UserID: id, Role: role, Version: version}, nil
 }
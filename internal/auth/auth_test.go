@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// legacyToken подписывает data так же, как GenerateToken, — для токенов
+// старых форматов.
+func legacyToken(data string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(data))
+	return base64.URLEncoding.EncodeToString([]byte(data + "." + hex.EncodeToString(mac.Sum(nil))))
+}
+
+func TestTokenRoundTrip(t *testing.T) {
+	token, err := GenerateToken(42, "admin", 3)
+	if err != nil {
+		t.Fatalf("GenerateToken: %v", err)
+	}
+	c, err := ParseToken(token)
+	if err != nil {
+		t.Fatalf("ParseToken: %v", err)
+	}
+	if c != (Claims{UserID: 42, Role: "admin", Version: 3}) {
+		t.Fatalf("claims = %+v", c)
+	}
+}
+
+func TestParseLegacyTokens(t *testing.T) {
+	tests := []struct {
+		data string
+		want Claims
+	}{
+		{"7", Claims{UserID: 7}},
+		{"7:user", Claims{UserID: 7, Role: "user"}},
+	}
+	for _, tt := range tests {
+		c, err := ParseToken(legacyToken(tt.data))
+		if err != nil {
+			t.Fatalf("ParseToken(%q): %v", tt.data, err)
+		}
+		if c != tt.want {
+			t.Errorf("ParseToken(%q) = %+v, want %+v", tt.data, c, tt.want)
+		}
+	}
+}
+
+func TestParseTokenRejects(t *testing.T) {
+	valid, err := GenerateToken(42, "user", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := base64.URLEncoding.DecodeString(valid)
+	forged := base64.URLEncoding.EncodeToString([]byte(strings.Replace(string(raw), "42:user:1", "42:user:0", 1)))
+
+	tests := map[string]string{
+		"not base64":     "%%%",
+		"no signature":   base64.URLEncoding.EncodeToString([]byte("42:user:1")),
+		"forged version": forged,
+		"bad version":    legacyToken("42:user:x"),
+	}
+	for name, token := range tests {
+		if _, err := ParseToken(token); err == nil {
+			t.Errorf("%s: ParseToken accepted %q", name, token)
+		}
+	}
+}
+
+func TestGenerateTokenBadRole(t *testing.T) {
+	for _, role := range []string{"a:b", "a.b"} {
+		if _, err := GenerateToken(1, role, 0); err == nil {
+			t.Errorf("GenerateToken accepted role %q", role)
+		}
+	}
+}
@@ -2,13 +2,40 @@ package config
 
 import (
 	"flag"
+	"log"
 	"os"
+	"strconv"
+	"time"
 )
 
 type Config struct {
 	RunAddress        string
 	DatabaseURI       string
 	AccrualSystemAddr string
+	TracesExporter    string
+	MetricsExporter   string
+	AdminToken        string
+	AdminLogins       string
+
+	AccrualCallbackSecret string
+
+	AccrualIdlePollInterval  time.Duration
+	AccrualReconcileWindow   time.Duration
+	AccrualReconcileInterval time.Duration
+	AccrualBatchSize         int
+	AccrualStallAfter        time.Duration
+	AccrualStallAttempts     int
+
+	AccrualBreakerFailures  int
+	AccrualBreakerSuccesses int
+	AccrualBreakerCooldown  time.Duration
+
+	IdempotencyTTL time.Duration
+
+	AuditHMACKey string
+	// AuditContextRetention — сколько хранятся IP, User-Agent, X-Request-ID
+	// и логины из журнала аудита; записи цепочки хранятся бессрочно.
+	AuditContextRetention time.Duration
 }
 
 func Load() *Config {
@@ -16,6 +43,21 @@ func Load() *Config {
 		RunAddress:        "localhost:8080",
 		DatabaseURI:       "",
 		AccrualSystemAddr: "",
+		TracesExporter:    "none",
+		MetricsExporter:   "none",
+
+		AccrualIdlePollInterval:  30 * time.Second,
+		AccrualReconcileInterval: time.Minute,
+		AccrualBatchSize:         10,
+		AccrualStallAfter:        72 * time.Hour,
+
+		AccrualBreakerFailures:  5,
+		AccrualBreakerSuccesses: 1,
+		AccrualBreakerCooldown:  30 * time.Second,
+
+		IdempotencyTTL: 24 * time.Hour,
+
+		AuditContextRetention: 90 * 24 * time.Hour,
 	}
 
 	if v := os.Getenv("RUN_ADDRESS"); v != "" {
@@ -27,12 +69,83 @@ func Load() *Config {
 	if v := os.Getenv("ACCRUAL_SYSTEM_ADDRESS"); v != "" {
 		cfg.AccrualSystemAddr = v
 	}
+	if v := os.Getenv("OTEL_TRACES_EXPORTER"); v != "" {
+		cfg.TracesExporter = v
+	}
+	if v := os.Getenv("OTEL_METRICS_EXPORTER"); v != "" {
+		cfg.MetricsExporter = v
+	}
+	if v := os.Getenv("ADMIN_TOKEN"); v != "" {
+		cfg.AdminToken = v
+	}
+	if v := os.Getenv("ADMIN_LOGINS"); v != "" {
+		cfg.AdminLogins = v
+	}
+	if v := os.Getenv("ACCRUAL_CALLBACK_SECRET"); v != "" {
+		cfg.AccrualCallbackSecret = v
+	}
+	durationEnv("ACCRUAL_IDLE_POLL_INTERVAL", &cfg.AccrualIdlePollInterval)
+	durationEnv("ACCRUAL_RECONCILE_WINDOW", &cfg.AccrualReconcileWindow)
+	durationEnv("ACCRUAL_RECONCILE_INTERVAL", &cfg.AccrualReconcileInterval)
+	intEnv("ACCRUAL_BATCH_SIZE", &cfg.AccrualBatchSize)
+	durationEnv("ACCRUAL_STALL_AFTER", &cfg.AccrualStallAfter)
+	intEnv("ACCRUAL_STALL_ATTEMPTS", &cfg.AccrualStallAttempts)
+	intEnv("ACCRUAL_BREAKER_FAILURES", &cfg.AccrualBreakerFailures)
+	intEnv("ACCRUAL_BREAKER_SUCCESSES", &cfg.AccrualBreakerSuccesses)
+	durationEnv("ACCRUAL_BREAKER_COOLDOWN", &cfg.AccrualBreakerCooldown)
+	durationEnv("IDEMPOTENCY_TTL", &cfg.IdempotencyTTL)
+	if v := os.Getenv("AUDIT_HMAC_KEY"); v != "" {
+		cfg.AuditHMACKey = v
+	}
+	durationEnv("AUDIT_CONTEXT_RETENTION", &cfg.AuditContextRetention)
 
 	flag.StringVar(&cfg.RunAddress, "a", cfg.RunAddress, "server address")
 	flag.StringVar(&cfg.DatabaseURI, "d", cfg.DatabaseURI, "database URI")
-	flag.StringVar(&cfg.AccrualSystemAddr, "r", cfg.AccrualSystemAddr, "accrual system address")
+	flag.StringVar(&cfg.AccrualSystemAddr, "r", cfg.AccrualSystemAddr, "accrual system address (http://, https:// or grpc://, grpcs://)")
+	flag.StringVar(&cfg.TracesExporter, "t", cfg.TracesExporter, "traces exporter: none, stdout or otlp")
+	flag.StringVar(&cfg.MetricsExporter, "m", cfg.MetricsExporter, "metrics exporter: none, stdout or otlp")
+	flag.StringVar(&cfg.AdminToken, "admin-token", cfg.AdminToken, "service token for /api/admin routes, empty disables it")
+	flag.StringVar(&cfg.AdminLogins, "admin-logins", cfg.AdminLogins, "comma-separated logins granted the admin role at startup")
+	flag.StringVar(&cfg.AccrualCallbackSecret, "accrual-callback-secret", cfg.AccrualCallbackSecret, "HMAC secret for pushed accrual results, empty disables the endpoint")
+	flag.DurationVar(&cfg.AccrualIdlePollInterval, "idle-poll-interval", cfg.AccrualIdlePollInterval, "accrual queue poll interval when there is nothing to do")
+	flag.DurationVar(&cfg.AccrualReconcileWindow, "reconcile-window", cfg.AccrualReconcileWindow, "recheck processed orders this long for accrual corrections, 0 disables")
+	flag.DurationVar(&cfg.AccrualReconcileInterval, "reconcile-interval", cfg.AccrualReconcileInterval, "accrual reconciliation pass interval")
+	flag.IntVar(&cfg.AccrualBatchSize, "accrual-batch-size", cfg.AccrualBatchSize, "orders requested from the accrual system per pass")
+	flag.DurationVar(&cfg.AccrualStallAfter, "stall-after", cfg.AccrualStallAfter, "move orders without a final accrual status to STALLED after this long, 0 disables")
+	flag.IntVar(&cfg.AccrualStallAttempts, "stall-attempts", cfg.AccrualStallAttempts, "move orders to STALLED after this many answered accrual polls, 0 disables")
+	flag.IntVar(&cfg.AccrualBreakerFailures, "breaker-failures", cfg.AccrualBreakerFailures, "consecutive accrual system failures that open the circuit breaker")
+	flag.IntVar(&cfg.AccrualBreakerSuccesses, "breaker-successes", cfg.AccrualBreakerSuccesses, "successful probes that close a half-open circuit breaker")
+	flag.DurationVar(&cfg.AccrualBreakerCooldown, "breaker-cooldown", cfg.AccrualBreakerCooldown, "how long the circuit breaker stays open before a probe")
+	flag.DurationVar(&cfg.IdempotencyTTL, "idempotency-ttl", cfg.IdempotencyTTL, "how long responses to Idempotency-Key requests are replayed, 0 keeps them forever")
+
+	flag.StringVar(&cfg.AuditHMACKey, "audit-hmac-key", cfg.AuditHMACKey, "HMAC key for the audit log hash chain, empty uses plain sha256")
+	flag.DurationVar(&cfg.AuditContextRetention, "audit-context-retention", cfg.AuditContextRetention, "how long IPs, user agents and logins are kept in the audit log, 0 keeps them until the account is deleted")
 
 	flag.Parse()
 
 	return cfg
 }
+
+func durationEnv(name string, dst *time.Duration) {
+	v := os.Getenv(name)
+	if v == "" {
+		return
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Fatalf("%s: %v", name, err)
+	}
+	*dst = d
+}
+
+func intEnv(name string, dst *int) {
+	v := os.Getenv(name)
+	if v == "" {
+		return
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Fatalf("%s: %v", name, err)
+	}
+	*dst = n
+}
@@ -0,0 +1,167 @@
+package events
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Bekw/go-practicum-diploma/internal/storage"
+)
+
+const (
+	// subscriberBuffer — сколько событий ждёт медленного клиента, прежде
+	// чем он будет отключён и продолжит по Last-Event-ID.
+	subscriberBuffer = 64
+
+	retention = 24 * time.Hour
+)
+
+// Broker раздаёт события пользователей подписчикам этого экземпляра.
+// Источник — PostgreSQL LISTEN/NOTIFY, поэтому событие, созданное на любой
+// реплике, доходит до клиентов, подключённых к любой другой.
+type Broker struct {
+	store *storage.Storage
+
+	mu   sync.Mutex
+	subs map[int64]map[*Subscription]struct{}
+}
+
+// Subscription — поток событий одного подключения. C закрывается, если
+// подписчик не успевает читать.
+type Subscription struct {
+	C      <-chan storage.UserEvent
+	ch     chan storage.UserEvent
+	userID int64
+}
+
+func NewBroker(store *storage.Storage) *Broker {
+	return &Broker{
+		store: store,
+		subs:  make(map[int64]map[*Subscription]struct{}),
+	}
+}
+
+func (b *Broker) Subscribe(userID int64) *Subscription {
+	ch := make(chan storage.UserEvent, subscriberBuffer)
+	sub := &Subscription{C: ch, ch: ch, userID: userID}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.subs[userID] == nil {
+		b.subs[userID] = make(map[*Subscription]struct{})
+	}
+	b.subs[userID][sub] = struct{}{}
+	return sub
+}
+
+func (b *Broker) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	set := b.subs[sub.userID]
+	if _, ok := set[sub]; !ok {
+		return
+	}
+	delete(set, sub)
+	if len(set) == 0 {
+		delete(b.subs, sub.userID)
+	}
+	close(sub.ch)
+}
+
+// Disconnect закрывает все подписки пользователя на этом экземпляре:
+// вызывается при удалении, блокировке и смене роли, чтобы открытые потоки
+// не переживали отзыв токена. Подключения к другим репликам закрываются
+// при следующей проверке пользователя в обработчике потока.
+func (b *Broker) Disconnect(userID int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for sub := range b.subs[userID] {
+		close(sub.ch)
+	}
+	delete(b.subs, userID)
+}
+
+// Run слушает UserEventsChannel, переподключаясь при обрывах, и раз в час
+// чистит старые события. Блокируется до отмены ctx.
+func (b *Broker) Run(ctx context.Context) {
+	go b.prune(ctx)
+
+	backoff := time.Second
+	for {
+		err := b.store.Listen(ctx, storage.UserEventsChannel, func(payload string) {
+			backoff = time.Second
+			b.dispatch(ctx, payload)
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("events listener stopped: %v, reconnecting in %s", err, backoff)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, 30*time.Second)
+	}
+}
+
+func (b *Broker) dispatch(ctx context.Context, payload string) {
+	userID, eventID, err := storage.ParseUserEventNotification(payload)
+	if err != nil {
+		log.Printf("events: %v", err)
+		return
+	}
+
+	if !b.hasSubscribers(userID) {
+		return
+	}
+
+	ev, err := b.store.GetUserEvent(ctx, eventID)
+	if err != nil {
+		log.Printf("events: load event %d: %v", eventID, err)
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for sub := range b.subs[userID] {
+		select {
+		case sub.ch <- *ev:
+		default:
+			// клиент не читает: отключаем, он вернётся с Last-Event-ID
+			delete(b.subs[userID], sub)
+			close(sub.ch)
+		}
+	}
+	if len(b.subs[userID]) == 0 {
+		delete(b.subs, userID)
+	}
+}
+
+func (b *Broker) hasSubscribers(userID int64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs[userID]) > 0
+}
+
+func (b *Broker) prune(ctx context.Context) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := b.store.PruneUserEvents(ctx, time.Now().Add(-retention)); err != nil {
+				log.Printf("events: prune: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package events
+
+import "testing"
+
+func TestBrokerDisconnect(t *testing.T) {
+	b := NewBroker(nil)
+	first := b.Subscribe(1)
+	second := b.Subscribe(1)
+	other := b.Subscribe(2)
+
+	b.Disconnect(1)
+
+	for _, sub := range []*Subscription{first, second} {
+		if _, ok := <-sub.C; ok {
+			t.Fatal("subscription of disconnected user is still open")
+		}
+	}
+	if b.hasSubscribers(1) {
+		t.Fatal("disconnected user still has subscribers")
+	}
+	if !b.hasSubscribers(2) {
+		t.Fatal("other user was disconnected")
+	}
+
+	// обработчик потока всё равно вызывает Unsubscribe
+	b.Unsubscribe(first)
+	b.Unsubscribe(other)
+	if b.hasSubscribers(2) {
+		t.Fatal("unsubscribe after disconnect left subscribers")
+	}
+}
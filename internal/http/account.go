@@ -0,0 +1,231 @@
+package http
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/Bekw/go-practicum-diploma/internal/auth"
+	"github.com/Bekw/go-practicum-diploma/internal/storage"
+)
+
+type exportProfile struct {
+	ID        int64  `json:"id"`
+	Login     string `json:"login"`
+	Role      string `json:"role"`
+	CreatedAt string `json:"created_at"`
+}
+
+type ledgerEntryResponse struct {
+	Kind   string  `json:"kind"`
+	Amount float64 `json:"amount"`
+	Order  string  `json:"order,omitempty"`
+	Reason string  `json:"reason,omitempty"`
+	At     string  `json:"at"`
+}
+
+// exportResponse — выгрузка данных пользователя. В ZIP каждое поле,
+// кроме exported_at, лежит отдельным файлом <поле>.json.
+type exportResponse struct {
+	ExportedAt  string                `json:"exported_at"`
+	Profile     exportProfile         `json:"profile"`
+	Balance     balanceResponse       `json:"balance"`
+	Orders      []orderResponse       `json:"orders"`
+	Withdrawals []withdrawalResponse  `json:"withdrawals"`
+	Ledger      []ledgerEntryResponse `json:"ledger"`
+}
+
+type deleteAccountRequest struct {
+	Password string `json:"password"`
+}
+
+// handleExport выгружает профиль, заказы, списания и движения баллов
+// пользователя. Формат — format=json (по умолчанию) или format=zip; без
+// параметра ZIP отдаётся, если клиент принимает только application/zip.
+func (h *Handler) handleExport(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r.Context())
+	if userID == 0 {
+		writeUnauthorized(w, r)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+		if strings.HasPrefix(r.Header.Get("Accept"), "application/zip") {
+			format = "zip"
+		}
+	}
+	if format != "json" && format != "zip" {
+		writeProblem(w, r, http.StatusBadRequest, codeInvalidQuery, fmt.Sprintf("%v: format must be json or zip", errBadQuery))
+		return
+	}
+
+	resp, err := h.buildExport(r, userID)
+	if err != nil {
+		writeInternalError(w, r)
+		return
+	}
+	h.audit(r, storage.AuditEntry{
+		Action:  storage.AuditActionUserExport,
+		UserID:  userID,
+		Details: map[string]any{"format": format},
+	})
+
+	filename := fmt.Sprintf("gophermart-export-%d.%s", userID, format)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+
+	if format == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.WriteHeader(http.StatusOK)
+	if err := writeExportZip(w, resp); err != nil {
+		// заголовки уже отправлены, клиент получит оборванный архив
+		log.Printf("export user %d: %v", userID, err)
+	}
+}
+
+func (h *Handler) buildExport(r *http.Request, userID int64) (*exportResponse, error) {
+	ctx := r.Context()
+
+	u, err := h.store.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	current, withdrawn, err := h.store.GetBalance(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	orders, err := h.store.ListOrdersByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	withdrawals, err := h.store.ListWithdrawalsByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	ledger, err := h.store.ListLedger(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &exportResponse{
+		ExportedAt: time.Now().UTC().Format(time.RFC3339),
+		Profile: exportProfile{
+			ID:        u.ID,
+			Login:     u.Login,
+			Role:      u.Role,
+			CreatedAt: u.CreatedAt.Format(time.RFC3339),
+		},
+		Balance:     balanceResponse{Current: current, Withdrawn: withdrawn},
+		Orders:      make([]orderResponse, len(orders)),
+		Withdrawals: make([]withdrawalResponse, len(withdrawals)),
+		Ledger:      make([]ledgerEntryResponse, len(ledger)),
+	}
+	for i := range orders {
+		resp.Orders[i] = newOrderResponse(&orders[i])
+	}
+	for i, it := range withdrawals {
+		resp.Withdrawals[i] = newWithdrawalResponse(it)
+	}
+	for i, e := range ledger {
+		resp.Ledger[i] = ledgerEntryResponse{
+			Kind:   e.Kind,
+			Amount: e.Amount,
+			Order:  e.Order.String,
+			Reason: e.Reason.String,
+			At:     e.At.Format(time.RFC3339),
+		}
+	}
+	return resp, nil
+}
+
+func writeExportZip(w http.ResponseWriter, resp *exportResponse) error {
+	zw := zip.NewWriter(w)
+
+	files := []struct {
+		name string
+		v    any
+	}{
+		{"profile.json", resp.Profile},
+		{"balance.json", resp.Balance},
+		{"orders.json", resp.Orders},
+		{"withdrawals.json", resp.Withdrawals},
+		{"ledger.json", resp.Ledger},
+	}
+	for _, f := range files {
+		fw, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     f.name,
+			Method:   zip.Deflate,
+			Modified: time.Now(),
+		})
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(fw)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(f.v); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// handleDeleteAccount закрывает учётную запись после подтверждения паролем.
+// Остаток баллов сгорает; финансовые записи сохраняются обезличенными
+// (см. storage.DeleteUser).
+func (h *Handler) handleDeleteAccount(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r.Context())
+	if userID == 0 {
+		writeUnauthorized(w, r)
+		return
+	}
+
+	var req deleteAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Password == "" {
+		writeProblem(w, r, http.StatusBadRequest, codeCredentialsRequired, "request body must be a JSON object with password")
+		return
+	}
+
+	u, err := h.store.GetUserByID(r.Context(), userID)
+	if err != nil {
+		writeInternalError(w, r)
+		return
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(req.Password)); err != nil {
+		writeProblem(w, r, http.StatusForbidden, codeInvalidCredentials, "password does not match")
+		return
+	}
+
+	err = h.store.DeleteUser(r.Context(), userID)
+	switch {
+	case errors.Is(err, storage.ErrUserNotFound):
+		writeUnauthorized(w, r)
+		return
+	case err != nil:
+		writeInternalError(w, r)
+		return
+	}
+	h.disconnectEvents(userID)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     auth.CookieName(),
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
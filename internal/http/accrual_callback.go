@@ -0,0 +1,144 @@
+package http
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Bekw/go-practicum-diploma/internal/accrual"
+	"github.com/Bekw/go-practicum-diploma/internal/storage"
+)
+
+const (
+	accrualSignatureHeader = "X-Accrual-Signature"
+	accrualTimestampHeader = "X-Accrual-Timestamp"
+
+	// accrualCallbackSkew — допустимое расхождение часов; старые подписанные
+	// запросы отклоняются, чтобы их нельзя было воспроизвести.
+	accrualCallbackSkew = 5 * time.Minute
+
+	maxAccrualCallbackBody  = 1 << 20
+	maxAccrualCallbackItems = 1000
+)
+
+const (
+	accrualCallbackApplied       = "applied"
+	accrualCallbackUnknownOrder  = "unknown_order"
+	accrualCallbackRejected      = "rejected"
+	accrualCallbackInvalidResult = "invalid"
+)
+
+// accrualCallbackMiddleware проверяет подпись
+// X-Accrual-Signature: sha256=<hex HMAC-SHA256(secret, timestamp + "." + body)>.
+func (h *Handler) accrualCallbackMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ts := r.Header.Get(accrualTimestampHeader)
+		sec, err := strconv.ParseInt(ts, 10, 64)
+		if err != nil {
+			writeProblem(w, r, http.StatusUnauthorized, codeInvalidSignature, "missing or malformed "+accrualTimestampHeader)
+			return
+		}
+		if d := time.Since(time.Unix(sec, 0)); d > accrualCallbackSkew || d < -accrualCallbackSkew {
+			writeProblem(w, r, http.StatusUnauthorized, codeInvalidSignature, "request timestamp is out of range")
+			return
+		}
+
+		body, ok := readLimitedBody(w, r, maxAccrualCallbackBody)
+		if !ok {
+			return
+		}
+
+		sig, err := hex.DecodeString(strings.TrimPrefix(r.Header.Get(accrualSignatureHeader), "sha256="))
+		if err != nil {
+			writeProblem(w, r, http.StatusUnauthorized, codeInvalidSignature, "malformed signature")
+			return
+		}
+
+		mac := hmac.New(sha256.New, []byte(h.opts.AccrualCallbackSecret))
+		mac.Write([]byte(ts))
+		mac.Write([]byte("."))
+		mac.Write(body)
+		if !hmac.Equal(mac.Sum(nil), sig) {
+			writeProblem(w, r, http.StatusUnauthorized, codeInvalidSignature, "signature mismatch")
+			return
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next.ServeHTTP(w, withAuditActor(r, storage.AuditActorAccrual, 0))
+	})
+}
+
+type accrualCallbackResult struct {
+	Order  string `json:"order"`
+	Result string `json:"result"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// handleAccrualCallback принимает результаты начислений, которые система
+// начислений присылает сама, — объектом или массивом в формате её ответа
+// GET /api/orders/{number}. Результаты применяются так же, как при опросе;
+// опрос остаётся для заказов, по которым уведомление не пришло.
+func (h *Handler) handleAccrualCallback(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, codeBadRequest, "failed to read request body")
+		return
+	}
+
+	body = bytes.TrimSpace(body)
+	single := len(body) > 0 && body[0] == '{'
+
+	var items []accrual.Result
+	if single {
+		var it accrual.Result
+		err = json.Unmarshal(body, &it)
+		items = []accrual.Result{it}
+	} else {
+		err = json.Unmarshal(body, &items)
+	}
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, codeInvalidJSON, "request body must be an accrual result object or an array of them")
+		return
+	}
+	if len(items) > maxAccrualCallbackItems {
+		writeProblem(w, r, http.StatusRequestEntityTooLarge, codeBatchTooLarge, "too many results in one request")
+		return
+	}
+
+	results := make([]accrualCallbackResult, len(items))
+	for i, it := range items {
+		results[i] = accrualCallbackResult{Order: it.Order, Result: accrualCallbackApplied}
+
+		err := accrual.ApplyResult(r.Context(), h.store, it)
+		switch {
+		case err == nil:
+		case errors.Is(err, storage.ErrOrderNotFound):
+			results[i].Result = accrualCallbackUnknownOrder
+		case errors.Is(err, accrual.ErrUnknownStatus), errors.Is(err, storage.ErrUnexpectedAccrual):
+			results[i].Result = accrualCallbackInvalidResult
+			results[i].Detail = err.Error()
+		case errors.Is(err, storage.ErrIllegalTransition):
+			results[i].Result = accrualCallbackRejected
+			results[i].Detail = err.Error()
+		default:
+			writeInternalError(w, r)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if single {
+		_ = json.NewEncoder(w).Encode(results[0])
+		return
+	}
+	_ = json.NewEncoder(w).Encode(results)
+}
@@ -0,0 +1,47 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccrualCallbackBodyErrors(t *testing.T) {
+	h := &Handler{opts: Options{AccrualCallbackSecret: testCallbackSecret}}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("handler must not be called")
+	})
+	mw := h.accrualCallbackMiddleware(next)
+
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	tests := []struct {
+		name   string
+		req    *http.Request
+		status int
+	}{
+		{
+			name:   "too large",
+			req:    httptest.NewRequest(http.MethodPost, "/api/internal/accrual", strings.NewReader(strings.Repeat(" ", maxAccrualCallbackBody+1))),
+			status: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:   "read error",
+			req:    httptest.NewRequest(http.MethodPost, "/api/internal/accrual", failingReader{}),
+			status: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.req.Header.Set(accrualTimestampHeader, ts)
+			rec := httptest.NewRecorder()
+			mw.ServeHTTP(rec, tt.req)
+			if rec.Code != tt.status {
+				t.Errorf("status %d, want %d", rec.Code, tt.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package http
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Bekw/go-practicum-diploma/internal/storage"
+)
+
+const adminTokenHeader = "X-Admin-Token"
+
+// adminMiddleware аутентифицирует запросы к /api/admin: пользователя по
+// токену в cookie либо сервисный токен в X-Admin-Token, который получает
+// роль admin. У запросов по сервисному токену нет пользователя: в журнале
+// аудита они записываются с actor_type service и без actor_id. Права на маршруты проверяет
+// requireRole.
+func (h *Handler) adminMiddleware(next http.Handler) http.Handler {
+	withUser := h.authMiddleware(next)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get(adminTokenHeader)
+		if token == "" {
+			withUser.ServeHTTP(w, r)
+			return
+		}
+		if h.opts.AdminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(h.opts.AdminToken)) != 1 {
+			writeUnauthorized(w, r)
+			return
+		}
+		r = withAuditActor(r, storage.AuditActorService, 0)
+		ctx := context.WithValue(r.Context(), userRoleCtxKey, storage.RoleAdmin)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// audit пишет в журнал действие, выполненное без транзакции Storage.
+// Сбой записи не отменяет уже выполненное действие и только логируется.
+func (h *Handler) audit(r *http.Request, e storage.AuditEntry) {
+	if err := h.store.RecordAudit(r.Context(), e); err != nil {
+		log.Printf("audit %s: %v", e.Action, err)
+	}
+}
+
+type reverseWithdrawalRequest struct {
+	Reason string `json:"reason"`
+}
+
+type withdrawalReversalResponse struct {
+	ID           int64   `json:"id"`
+	WithdrawalID int64   `json:"withdrawal_id"`
+	UserID       int64   `json:"user_id"`
+	Sum          float64 `json:"sum"`
+	Reason       string  `json:"reason"`
+	CreatedAt    string  `json:"created_at"`
+}
+
+func (h *Handler) handleReverseWithdrawal(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || id <= 0 {
+		writeProblem(w, r, http.StatusBadRequest, codeBadRequest, "withdrawal id must be a positive integer")
+		return
+	}
+
+	var req reverseWithdrawalRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, codeInvalidJSON, "request body must be a JSON object with reason")
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" {
+		writeProblem(w, r, http.StatusBadRequest, codeReasonRequired, "reason is required")
+		return
+	}
+
+	rev, err := h.store.ReverseWithdrawal(r.Context(), id, req.Reason)
+	switch {
+	case errors.Is(err, storage.ErrWithdrawalNotFound):
+		writeProblem(w, r, http.StatusNotFound, codeWithdrawalNotFound, "withdrawal not found")
+		return
+	case errors.Is(err, storage.ErrWithdrawalAlreadyReversed):
+		writeProblem(w, r, http.StatusConflict, codeWithdrawalAlreadyReversed, "withdrawal was already reversed")
+		return
+	case err != nil:
+		writeInternalError(w, r)
+		return
+	}
+
+	resp := withdrawalReversalResponse{
+		ID:           rev.ID,
+		WithdrawalID: rev.WithdrawalID,
+		UserID:       rev.UserID,
+		Sum:          rev.Sum,
+		Reason:       rev.Reason,
+		CreatedAt:    rev.CreatedAt.Format(time.RFC3339),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// handleRequeueOrder возвращает зависший заказ в очередь начислений.
+func (h *Handler) handleRequeueOrder(w http.ResponseWriter, r *http.Request) {
+	o, err := h.store.RequeueOrder(r.Context(), chi.URLParam(r, "number"), getUserID(r.Context()))
+	switch {
+	case errors.Is(err, storage.ErrOrderNotFound):
+		writeProblem(w, r, http.StatusNotFound, codeOrderNotFound, "order not found")
+		return
+	case errors.Is(err, storage.ErrOrderNotStalled):
+		writeProblem(w, r, http.StatusConflict, codeOrderNotStalled, "only STALLED orders can be requeued")
+		return
+	case err != nil:
+		writeInternalError(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(newOrderResponse(o))
+}
@@ -0,0 +1,344 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Bekw/go-practicum-diploma/internal/storage"
+)
+
+type adminUserResponse struct {
+	ID         int64           `json:"id"`
+	Login      string          `json:"login"`
+	Role       string          `json:"role"`
+	CreatedAt  string          `json:"created_at"`
+	Locked     bool            `json:"locked"`
+	LockedAt   string          `json:"locked_at,omitempty"`
+	LockReason string          `json:"lock_reason,omitempty"`
+	DeletedAt  string          `json:"deleted_at,omitempty"`
+	Balance    balanceResponse `json:"balance"`
+}
+
+type balanceAdjustmentRequest struct {
+	Amount float64 `json:"amount"`
+	Reason string  `json:"reason"`
+}
+
+type balanceAdjustmentResponse struct {
+	ID        int64   `json:"id"`
+	UserID    int64   `json:"user_id"`
+	Amount    float64 `json:"amount"`
+	Reason    string  `json:"reason"`
+	CreatedAt string  `json:"created_at"`
+}
+
+type lockUserRequest struct {
+	Reason string `json:"reason"`
+}
+
+type setUserRoleRequest struct {
+	Role string `json:"role"`
+}
+
+// handleAdminFindUser ищет пользователя по точному логину (?login=).
+func (h *Handler) handleAdminFindUser(w http.ResponseWriter, r *http.Request) {
+	login := strings.TrimSpace(r.URL.Query().Get("login"))
+	if login == "" {
+		writeProblem(w, r, http.StatusBadRequest, codeInvalidQuery, "login query parameter is required")
+		return
+	}
+
+	u, err := h.store.GetUserByLogin(r.Context(), login)
+	if errors.Is(err, storage.ErrUserNotFound) {
+		writeProblem(w, r, http.StatusNotFound, codeUserNotFound, "user not found")
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r)
+		return
+	}
+
+	h.auditUserView(r, u.ID, "profile")
+	h.writeAdminUser(w, r, u)
+}
+
+func (h *Handler) handleAdminGetUser(w http.ResponseWriter, r *http.Request) {
+	u, ok := h.adminTargetUser(w, r)
+	if !ok {
+		return
+	}
+	h.auditUserView(r, u.ID, "profile")
+	h.writeAdminUser(w, r, u)
+}
+
+func (h *Handler) handleAdminGetUserOrders(w http.ResponseWriter, r *http.Request) {
+	u, ok := h.adminTargetUser(w, r)
+	if !ok {
+		return
+	}
+	h.auditUserView(r, u.ID, "orders")
+	h.writeOrders(w, r, u.ID)
+}
+
+func (h *Handler) handleAdminGetUserWithdrawals(w http.ResponseWriter, r *http.Request) {
+	u, ok := h.adminTargetUser(w, r)
+	if !ok {
+		return
+	}
+	h.auditUserView(r, u.ID, "withdrawals")
+	h.writeWithdrawals(w, r, u.ID)
+}
+
+func (h *Handler) handleAdminGetUserBalance(w http.ResponseWriter, r *http.Request) {
+	u, ok := h.adminTargetUser(w, r)
+	if !ok {
+		return
+	}
+	h.auditUserView(r, u.ID, "balance")
+	h.writeBalance(w, r, u.ID)
+}
+
+func (h *Handler) handleAdminAdjustBalance(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseUserIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	var req balanceAdjustmentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, codeInvalidJSON, "request body must be a JSON object with amount and reason")
+		return
+	}
+	if req.Amount == 0 || math.IsNaN(req.Amount) || math.IsInf(req.Amount, 0) {
+		writeProblem(w, r, http.StatusUnprocessableEntity, codeInvalidAdjustment, "amount must be a non-zero number")
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" {
+		writeProblem(w, r, http.StatusBadRequest, codeReasonRequired, "reason is required")
+		return
+	}
+
+	adj, err := h.store.AdjustBalance(r.Context(), id, req.Amount, req.Reason, getUserID(r.Context()))
+	switch {
+	case errors.Is(err, storage.ErrUserNotFound):
+		writeProblem(w, r, http.StatusNotFound, codeUserNotFound, "user not found")
+		return
+	case errors.Is(err, storage.ErrInsufficientFunds):
+		writeProblem(w, r, http.StatusConflict, codeInsufficientFunds, "adjustment would make the balance negative")
+		return
+	case err != nil:
+		writeInternalError(w, r)
+		return
+	}
+
+	resp := balanceAdjustmentResponse{
+		ID:        adj.ID,
+		UserID:    adj.UserID,
+		Amount:    adj.Amount,
+		Reason:    adj.Reason,
+		CreatedAt: adj.CreatedAt.Format(time.RFC3339),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func (h *Handler) handleAdminLockUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseUserIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	var req lockUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, codeInvalidJSON, "request body must be a JSON object with reason")
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" {
+		writeProblem(w, r, http.StatusBadRequest, codeReasonRequired, "reason is required")
+		return
+	}
+
+	if !h.canManageUser(w, r, id) {
+		return
+	}
+
+	err := h.store.LockUser(r.Context(), id, req.Reason, getUserID(r.Context()))
+	if err == nil {
+		h.disconnectEvents(id)
+	}
+	h.writeLockResult(w, r, id, err)
+}
+
+func (h *Handler) handleAdminUnlockUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseUserIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	if !h.canManageUser(w, r, id) {
+		return
+	}
+
+	err := h.store.UnlockUser(r.Context(), id, getUserID(r.Context()))
+	h.writeLockResult(w, r, id, err)
+}
+
+func (h *Handler) handleAdminSetUserRole(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseUserIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	var req setUserRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, codeInvalidJSON, "request body must be a JSON object with role")
+		return
+	}
+	if !isKnownRole(req.Role) {
+		writeProblem(w, r, http.StatusUnprocessableEntity, codeInvalidRole, "role must be one of user, support, admin, merchant")
+		return
+	}
+
+	err := h.store.ChangeUserRole(r.Context(), id, req.Role, getUserID(r.Context()))
+	if errors.Is(err, storage.ErrUserNotFound) {
+		writeProblem(w, r, http.StatusNotFound, codeUserNotFound, "user not found")
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r)
+		return
+	}
+	h.disconnectEvents(id)
+
+	u, err := h.store.GetUserByID(r.Context(), id)
+	if err != nil {
+		writeInternalError(w, r)
+		return
+	}
+	h.writeAdminUser(w, r, u)
+}
+
+func (h *Handler) writeLockResult(w http.ResponseWriter, r *http.Request, userID int64, err error) {
+	switch {
+	case errors.Is(err, storage.ErrUserNotFound):
+		writeProblem(w, r, http.StatusNotFound, codeUserNotFound, "user not found")
+		return
+	case errors.Is(err, storage.ErrUserAlreadyLocked):
+		writeProblem(w, r, http.StatusConflict, codeUserAlreadyLocked, "user is already locked")
+		return
+	case errors.Is(err, storage.ErrUserNotLocked):
+		writeProblem(w, r, http.StatusConflict, codeUserNotLocked, "user is not locked")
+		return
+	case err != nil:
+		writeInternalError(w, r)
+		return
+	}
+
+	u, err := h.store.GetUserByID(r.Context(), userID)
+	if err != nil {
+		writeInternalError(w, r)
+		return
+	}
+	h.writeAdminUser(w, r, u)
+}
+
+// canManageUser не даёт поддержке блокировать сотрудников: учётные записи
+// с ролями support и admin меняет только admin. При отказе ответ уже
+// записан.
+func (h *Handler) canManageUser(w http.ResponseWriter, r *http.Request, userID int64) bool {
+	if getUserRole(r.Context()) == storage.RoleAdmin {
+		return true
+	}
+
+	u, err := h.store.GetUserByID(r.Context(), userID)
+	if errors.Is(err, storage.ErrUserNotFound) {
+		writeProblem(w, r, http.StatusNotFound, codeUserNotFound, "user not found")
+		return false
+	}
+	if err != nil {
+		writeInternalError(w, r)
+		return false
+	}
+	if slices.Contains(rolesSupport, u.Role) {
+		writeProblem(w, r, http.StatusForbidden, codeForbidden, "only admins can manage staff accounts")
+		return false
+	}
+	return true
+}
+
+// auditUserView записывает просмотр данных пользователя сотрудником.
+func (h *Handler) auditUserView(r *http.Request, userID int64, resource string) {
+	h.audit(r, storage.AuditEntry{
+		Action:  storage.AuditActionUserView,
+		UserID:  userID,
+		Details: map[string]any{"resource": resource},
+	})
+}
+
+// adminTargetUser читает пользователя из {id}. При ошибке ответ уже записан.
+func (h *Handler) adminTargetUser(w http.ResponseWriter, r *http.Request) (*storage.User, bool) {
+	id, ok := parseUserIDParam(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	u, err := h.store.GetUserByID(r.Context(), id)
+	if errors.Is(err, storage.ErrUserNotFound) {
+		writeProblem(w, r, http.StatusNotFound, codeUserNotFound, "user not found")
+		return nil, false
+	}
+	if err != nil {
+		writeInternalError(w, r)
+		return nil, false
+	}
+	return u, true
+}
+
+func parseUserIDParam(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || id <= 0 {
+		writeProblem(w, r, http.StatusBadRequest, codeBadRequest, "user id must be a positive integer")
+		return 0, false
+	}
+	return id, true
+}
+
+func (h *Handler) writeAdminUser(w http.ResponseWriter, r *http.Request, u *storage.User) {
+	current, withdrawn, err := h.store.GetBalance(r.Context(), u.ID)
+	if err != nil {
+		writeInternalError(w, r)
+		return
+	}
+
+	resp := adminUserResponse{
+		ID:        u.ID,
+		Login:     u.Login,
+		Role:      u.Role,
+		CreatedAt: u.CreatedAt.Format(time.RFC3339),
+		Locked:    u.Locked(),
+		Balance:   balanceResponse{Current: current, Withdrawn: withdrawn},
+	}
+	if u.LockedAt.Valid {
+		resp.LockedAt = u.LockedAt.Time.Format(time.RFC3339)
+		resp.LockReason = u.LockReason.String
+	}
+	if u.DeletedAt.Valid {
+		resp.DeletedAt = u.DeletedAt.Time.Format(time.RFC3339)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,190 @@
+package http
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Bekw/go-practicum-diploma/internal/storage"
+)
+
+const webhookDeliveriesLimit = 100
+
+type webhookEndpointRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Secret string   `json:"secret,omitempty"`
+}
+
+type webhookEndpointResponse struct {
+	ID        int64    `json:"id"`
+	URL       string   `json:"url"`
+	Events    []string `json:"events"`
+	Active    bool     `json:"active"`
+	Secret    string   `json:"secret,omitempty"`
+	CreatedAt string   `json:"created_at"`
+}
+
+type webhookDeliveryResponse struct {
+	ID             int64  `json:"id"`
+	EventID        int64  `json:"event_id"`
+	EventType      string `json:"event_type"`
+	Status         string `json:"status"`
+	Attempts       int    `json:"attempts"`
+	NextAttemptAt  string `json:"next_attempt_at,omitempty"`
+	LastStatusCode *int   `json:"last_status_code,omitempty"`
+	LastError      string `json:"last_error,omitempty"`
+	DeliveredAt    string `json:"delivered_at,omitempty"`
+}
+
+func isKnownWebhookEvent(e string) bool {
+	switch e {
+	case storage.WebhookEventOrderProcessed, storage.WebhookEventOrderInvalid, storage.WebhookEventOrderStalled,
+		storage.WebhookEventWithdrawalCreate:
+		return true
+	}
+	return false
+}
+
+// handleCreateWebhook регистрирует получателя. Если секрет не передан,
+// он генерируется и возвращается один раз — в этом ответе.
+func (h *Handler) handleCreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var req webhookEndpointRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, codeInvalidJSON, "request body must be a JSON object with url and events")
+		return
+	}
+
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		writeProblem(w, r, http.StatusBadRequest, codeInvalidWebhook, "url must be an absolute http(s) URL")
+		return
+	}
+	if len(req.Events) == 0 {
+		writeProblem(w, r, http.StatusBadRequest, codeInvalidWebhook, "at least one event is required")
+		return
+	}
+	for _, e := range req.Events {
+		if !isKnownWebhookEvent(e) {
+			writeProblem(w, r, http.StatusBadRequest, codeInvalidWebhook, "unknown event "+strconv.Quote(e))
+			return
+		}
+	}
+
+	if req.Secret == "" {
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			writeInternalError(w, r)
+			return
+		}
+		req.Secret = hex.EncodeToString(buf)
+	}
+
+	ep, err := h.store.CreateWebhookEndpoint(r.Context(), req.URL, req.Secret, req.Events)
+	if err != nil {
+		writeInternalError(w, r)
+		return
+	}
+
+	resp := newWebhookEndpointResponse(ep)
+	resp.Secret = ep.Secret
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func (h *Handler) handleListWebhooks(w http.ResponseWriter, r *http.Request) {
+	items, err := h.store.ListWebhookEndpoints(r.Context())
+	if err != nil {
+		writeInternalError(w, r)
+		return
+	}
+
+	resp := make([]webhookEndpointResponse, len(items))
+	for i := range items {
+		resp[i] = newWebhookEndpointResponse(&items[i])
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func (h *Handler) handleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || id <= 0 {
+		writeProblem(w, r, http.StatusBadRequest, codeBadRequest, "webhook id must be a positive integer")
+		return
+	}
+
+	err = h.store.DeactivateWebhookEndpoint(r.Context(), id)
+	switch {
+	case errors.Is(err, storage.ErrWebhookNotFound):
+		writeProblem(w, r, http.StatusNotFound, codeWebhookNotFound, "webhook endpoint not found")
+		return
+	case err != nil:
+		writeInternalError(w, r)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) handleListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || id <= 0 {
+		writeProblem(w, r, http.StatusBadRequest, codeBadRequest, "webhook id must be a positive integer")
+		return
+	}
+
+	items, err := h.store.ListWebhookDeliveries(r.Context(), id, webhookDeliveriesLimit)
+	if err != nil {
+		writeInternalError(w, r)
+		return
+	}
+
+	resp := make([]webhookDeliveryResponse, len(items))
+	for i, d := range items {
+		dr := webhookDeliveryResponse{
+			ID:        d.ID,
+			EventID:   d.OutboxID,
+			EventType: d.EventType,
+			Status:    d.Status,
+			Attempts:  d.Attempts,
+			LastError: d.LastError.String,
+		}
+		if d.Status == storage.WebhookDeliveryPending {
+			dr.NextAttemptAt = d.NextAttemptAt.Format(time.RFC3339)
+		}
+		if d.LastCode.Valid {
+			code := int(d.LastCode.Int64)
+			dr.LastStatusCode = &code
+		}
+		if d.DeliveredAt.Valid {
+			dr.DeliveredAt = d.DeliveredAt.Time.Format(time.RFC3339)
+		}
+		resp[i] = dr
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func newWebhookEndpointResponse(ep *storage.WebhookEndpoint) webhookEndpointResponse {
+	return webhookEndpointResponse{
+		ID:        ep.ID,
+		URL:       ep.URL,
+		Events:    ep.Events,
+		Active:    ep.Active,
+		CreatedAt: ep.CreatedAt.Format(time.RFC3339),
+	}
+}
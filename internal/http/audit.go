@@ -0,0 +1,72 @@
+package http
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"net/http"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/Bekw/go-practicum-diploma/internal/storage"
+)
+
+const (
+	requestIDHeader = "X-Request-ID"
+
+	maxRequestIDLen = 128
+	maxUserAgentLen = 512
+)
+
+// auditMetaMiddleware кладёт в контекст сведения о запросе для журнала
+// аудита (см. storage.AuditMeta). Идентификатор запроса берётся из
+// X-Request-ID, иначе из трассировки, и возвращается в ответе.
+// Пока аутентификация не пройдена, запрос считается анонимным.
+func auditMetaMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m := storage.AuditMeta{
+			ActorType: storage.AuditActorAnonymous,
+			IP:        clientIP(r),
+			UserAgent: truncate(r.UserAgent(), maxUserAgentLen),
+			RequestID: requestID(r),
+		}
+		w.Header().Set(requestIDHeader, m.RequestID)
+
+		next.ServeHTTP(w, r.WithContext(storage.WithAuditMeta(r.Context(), m)))
+	})
+}
+
+// withAuditActor уточняет, от чьего имени выполняется запрос.
+func withAuditActor(r *http.Request, actorType string, actorID int64) *http.Request {
+	m, _ := storage.AuditMetaFrom(r.Context())
+	m.ActorType = actorType
+	m.ActorID = actorID
+	return r.WithContext(storage.WithAuditMeta(r.Context(), m))
+}
+
+func requestID(r *http.Request) string {
+	if id := r.Header.Get(requestIDHeader); id != "" {
+		return truncate(id, maxRequestIDLen)
+	}
+	if sc := trace.SpanContextFromContext(r.Context()); sc.HasTraceID() {
+		return sc.TraceID().String()
+	}
+	buf := make([]byte, 16)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
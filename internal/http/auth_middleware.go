@@ -2,30 +2,70 @@ package http
 
 import (
 	"context"
+	"errors"
 	"net/http"
 
 	"github.com/Bekw/go-practicum-diploma/internal/auth"
+	"github.com/Bekw/go-practicum-diploma/internal/storage"
 )
 
 type contextKey string
 
-const userIDCtxKey contextKey = "userID"
+const (
+	userIDCtxKey           contextKey = "userID"
+	userRoleCtxKey         contextKey = "userRole"
+	userTokenVersionCtxKey contextKey = "userTokenVersion"
+)
 
+// authMiddleware пропускает запросы с действующим токеном и кладёт в
+// контекст пользователя и его роль. Пользователь читается из БД на каждый
+// запрос, чтобы блокировка и удаление учётной записи действовали и на
+// выданные ранее токены; токен с ролью или версией, отличной от текущей,
+// считается устаревшим (см. storage.User.TokenVersion).
 func (h *Handler) authMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		cookie, err := r.Cookie(auth.CookieName())
 		if err != nil {
-			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			writeUnauthorized(w, r)
 			return
 		}
 
-		userID, err := auth.ParseToken(cookie.Value)
-		if err != nil || userID == 0 {
-			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		claims, err := auth.ParseToken(cookie.Value)
+		if err != nil || claims.UserID == 0 {
+			writeUnauthorized(w, r)
+			return
+		}
+
+		user, err := h.store.GetUserByID(r.Context(), claims.UserID)
+		if errors.Is(err, storage.ErrUserNotFound) {
+			writeUnauthorized(w, r)
+			return
+		}
+		if err != nil {
+			writeInternalError(w, r)
+			return
+		}
+		if user.Deleted() {
+			writeUnauthorized(w, r)
+			return
+		}
+		if user.Locked() {
+			writeAccountLocked(w, r)
+			return
+		}
+		// токены до появления ролей выдавались только обычным пользователям
+		if claims.Role == "" {
+			claims.Role = storage.RoleUser
+		}
+		if claims.Role != user.Role || claims.Version != user.TokenVersion {
+			writeUnauthorized(w, r)
 			return
 		}
 
-		ctx := context.WithValue(r.Context(), userIDCtxKey, userID)
+		r = withAuditActor(r, storage.AuditActorUser, user.ID)
+		ctx := context.WithValue(r.Context(), userIDCtxKey, user.ID)
+		ctx = context.WithValue(ctx, userRoleCtxKey, user.Role)
+		ctx = context.WithValue(ctx, userTokenVersionCtxKey, user.TokenVersion)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
@@ -38,3 +78,17 @@ func getUserID(ctx context.Context) int64 {
 	id, _ := v.(int64)
 	return id
 }
+
+func getUserRole(ctx context.Context) string {
+	role, _ := ctx.Value(userRoleCtxKey).(string)
+	return role
+}
+
+func getUserTokenVersion(ctx context.Context) int {
+	v, _ := ctx.Value(userTokenVersionCtxKey).(int)
+	return v
+}
+
+func writeAccountLocked(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, http.StatusForbidden, codeAccountLocked, "account is locked, contact support")
+}
@@ -2,8 +2,11 @@ package http
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"time"
+
+	"github.com/Bekw/go-practicum-diploma/internal/storage"
 )
 
 type balanceResponse struct {
@@ -14,24 +17,33 @@ type balanceResponse struct {
 type withdrawRequest struct {
 	Order string  `json:"order"`
 	Sum   float64 `json:"sum"`
+	// AllowDuplicate разрешает повторное списание по уже использованному номеру заказа.
+	AllowDuplicate bool `json:"allow_duplicate,omitempty"`
 }
 
 type withdrawalResponse struct {
+	ID          int64   `json:"id"`
 	Order       string  `json:"order"`
 	Sum         float64 `json:"sum"`
+	Status      string  `json:"status"`
 	ProcessedAt string  `json:"processed_at"`
+	ReversedAt  string  `json:"reversed_at,omitempty"`
 }
 
 func (h *Handler) handleGetBalance(w http.ResponseWriter, r *http.Request) {
 	userID := getUserID(r.Context())
 	if userID == 0 {
-		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		writeUnauthorized(w, r)
 		return
 	}
 
+	h.writeBalance(w, r, userID)
+}
+
+func (h *Handler) writeBalance(w http.ResponseWriter, r *http.Request, userID int64) {
 	current, withdrawn, err := h.store.GetBalance(r.Context(), userID)
 	if err != nil {
-		http.Error(w, "internal error", http.StatusInternalServerError)
+		writeInternalError(w, r)
 		return
 	}
 
@@ -47,38 +59,39 @@ func (h *Handler) handleGetBalance(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) handleWithdraw(w http.ResponseWriter, r *http.Request) {
 	userID := getUserID(r.Context())
 	if userID == 0 {
-		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		writeUnauthorized(w, r)
 		return
 	}
 
 	var req withdrawRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "bad request", http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, codeInvalidJSON, "request body must be a JSON object with order and sum")
 		return
 	}
-	if req.Order == "" || req.Sum <= 0 {
-		http.Error(w, "bad request", http.StatusBadRequest)
+	if req.Order == "" {
+		writeProblem(w, r, http.StatusBadRequest, codeEmptyOrderNumber, "order number is required")
 		return
 	}
-
-	if !isLuhnValid(req.Order) {
-		w.WriteHeader(http.StatusUnprocessableEntity)
+	if req.Sum <= 0 {
+		writeProblem(w, r, http.StatusBadRequest, codeInvalidWithdrawalSum, "sum must be positive")
 		return
 	}
 
-	current, _, err := h.store.GetBalance(r.Context(), userID)
-	if err != nil {
-		http.Error(w, "internal error", http.StatusInternalServerError)
+	if !isLuhnValid(req.Order) {
+		writeProblem(w, r, http.StatusUnprocessableEntity, codeInvalidOrderNumber, "order number fails the Luhn check")
 		return
 	}
 
-	if current+1e-9 < req.Sum {
-		w.WriteHeader(http.StatusPaymentRequired)
+	err := h.store.CreateWithdrawal(r.Context(), userID, req.Order, req.Sum, req.AllowDuplicate)
+	switch {
+	case errors.Is(err, storage.ErrInsufficientFunds):
+		writeProblem(w, r, http.StatusPaymentRequired, codeInsufficientFunds, "not enough points on balance")
 		return
-	}
-
-	if err := h.store.CreateWithdrawal(r.Context(), userID, req.Order, req.Sum); err != nil {
-		http.Error(w, "internal error", http.StatusInternalServerError)
+	case errors.Is(err, storage.ErrDuplicateWithdrawal):
+		writeProblem(w, r, http.StatusConflict, codeDuplicateWithdrawal, "points were already withdrawn for this order")
+		return
+	case err != nil:
+		writeInternalError(w, r)
 		return
 	}
 
@@ -87,15 +100,27 @@ func (h *Handler) handleWithdraw(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) handleGetWithdrawals(w http.ResponseWriter, r *http.Request) {
 	userID := getUserID(r.Context())
 	if userID == 0 {
-		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		writeUnauthorized(w, r)
 		return
 	}
 
-	items, err := h.store.ListWithdrawalsByUser(r.Context(), userID)
+	h.writeWithdrawals(w, r, userID)
+}
+
+// writeWithdrawals отдаёт страницу списаний пользователя по параметрам запроса.
+func (h *Handler) writeWithdrawals(w http.ResponseWriter, r *http.Request, userID int64) {
+	page, err := parsePageParams(r.URL.Query())
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, codeInvalidQuery, err.Error())
+		return
+	}
+
+	items, next, err := h.store.ListWithdrawalsByUserPage(r.Context(), userID, page.after, page.limit, page.asc)
 	if err != nil {
-		http.Error(w, "internal error", http.StatusInternalServerError)
+		writeInternalError(w, r)
 		return
 	}
+	setNextPage(w, r, next, page.limit)
 
 	if len(items) == 0 {
 		w.WriteHeader(http.StatusNoContent)
@@ -104,14 +129,24 @@ func (h *Handler) handleGetWithdrawals(w http.ResponseWriter, r *http.Request) {
 
 	resp := make([]withdrawalResponse, 0, len(items))
 	for _, it := range items {
-		resp = append(resp, withdrawalResponse{
-			Order:       it.OrderNumber,
-			Sum:         it.Sum,
-			ProcessedAt: it.ProcessedAt.Format(time.RFC3339),
-		})
+		resp = append(resp, newWithdrawalResponse(it))
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	_ = json.NewEncoder(w).Encode(resp)
 }
+
+func newWithdrawalResponse(it storage.Withdrawal) withdrawalResponse {
+	wr := withdrawalResponse{
+		ID:          it.ID,
+		Order:       it.OrderNumber,
+		Sum:         it.Sum,
+		Status:      it.Status(),
+		ProcessedAt: it.ProcessedAt.Format(time.RFC3339),
+	}
+	if it.ReversedAt.Valid {
+		wr.ReversedAt = it.ReversedAt.Time.Format(time.RFC3339)
+	}
+	return wr
+}
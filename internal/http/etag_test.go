@@ -0,0 +1,51 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestETagMatches(t *testing.T) {
+	const etag = `"abc"`
+
+	tests := []struct {
+		name   string
+		header string
+		want   bool
+	}{
+		{name: "no header", header: "", want: false},
+		{name: "exact", header: `"abc"`, want: true},
+		{name: "wildcard", header: "*", want: true},
+		{name: "weak", header: `W/"abc"`, want: true},
+		{name: "list", header: `"x", W/"abc" , "y"`, want: true},
+		{name: "mismatch", header: `"abd"`, want: false},
+		{name: "list mismatch", header: `"x", "y"`, want: false},
+		{name: "unquoted", header: "abc", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := etagMatches(tt.header, etag); got != tt.want {
+				t.Errorf("etagMatches(%q) = %v, want %v", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteJSONWithETagNotModified(t *testing.T) {
+	rec := httptest.NewRecorder()
+	writeJSONWithETag(rec, httptest.NewRequest(http.MethodGet, "/", nil), map[string]int{"a": 1})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d", rec.Code)
+	}
+	etag := rec.Header().Get("ETag")
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	writeJSONWithETag(rec, req, map[string]int{"a": 1})
+	if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Errorf("status %d, body %q; want 304 without body", rec.Code, rec.Body)
+	}
+}
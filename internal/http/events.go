@@ -0,0 +1,147 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Bekw/go-practicum-diploma/internal/storage"
+)
+
+const (
+	sseHeartbeatInterval = 15 * time.Second
+	// sseReplayPage — сколько пропущенных событий читается из БД за раз.
+	sseReplayPage = 500
+)
+
+// handleEvents — поток Server-Sent Events со сменами статусов заказов и
+// баланса текущего пользователя. Клиент, переподключившийся с
+// Last-Event-ID, сначала получает все пропущенные события из БД,
+// страницами по sseReplayPage, и только потом новые.
+func (h *Handler) handleEvents(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r.Context())
+	if userID == 0 {
+		writeUnauthorized(w, r)
+		return
+	}
+
+	var lastID int64
+	if v := r.Header.Get("Last-Event-ID"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || id < 0 {
+			writeProblem(w, r, http.StatusBadRequest, codeBadRequest, "Last-Event-ID must be a non-negative integer")
+			return
+		}
+		lastID = id
+	}
+
+	ctx := r.Context()
+	rc := http.NewResponseController(w)
+
+	// подписываемся до чтения из БД, чтобы не потерять события между ними;
+	// дубликаты отсекаются по lastID
+	sub := h.events.Subscribe(userID)
+	defer h.events.Unsubscribe(sub)
+
+	var backlog []storage.UserEvent
+	if lastID > 0 {
+		var err error
+		backlog, err = h.store.ListUserEventsAfter(ctx, userID, lastID, sseReplayPage)
+		if err != nil {
+			writeInternalError(w, r)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(ev storage.UserEvent) error {
+		if ev.ID <= lastID {
+			return nil
+		}
+		if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, ev.Payload); err != nil {
+			return err
+		}
+		lastID = ev.ID
+		return rc.Flush()
+	}
+
+	if _, err := fmt.Fprintf(w, "retry: %d\n\n", (3 * time.Second).Milliseconds()); err != nil {
+		return
+	}
+	// первая страница прочитана до заголовков, чтобы сбой БД дал 500;
+	// при сбое на следующих клиент переподключится с последним полученным id
+	for len(backlog) > 0 {
+		for _, ev := range backlog {
+			if err := send(ev); err != nil {
+				return
+			}
+		}
+		if len(backlog) < sseReplayPage {
+			break
+		}
+		var err error
+		backlog, err = h.store.ListUserEventsAfter(ctx, userID, lastID, sseReplayPage)
+		if err != nil {
+			return
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-heartbeat.C:
+			// токен мог быть отозван после подключения, в том числе на
+			// другой реплике, где Disconnect этого брокера не вызывается
+			if !h.streamAllowed(r, userID) {
+				return
+			}
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		case ev, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if err := send(ev); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// streamAllowed перепроверяет пользователя открытого потока так же, как
+// authMiddleware: удалённый, заблокированный или сменивший версию токена
+// пользователь поток не получает.
+func (h *Handler) streamAllowed(r *http.Request, userID int64) bool {
+	user, err := h.store.GetUserByID(r.Context(), userID)
+	if err != nil {
+		// при сбое БД поток не рвём: проверка повторится на следующем тике
+		return !errors.Is(err, storage.ErrUserNotFound)
+	}
+	return !user.Deleted() && !user.Locked() && user.TokenVersion == getUserTokenVersion(r.Context())
+}
+
+// disconnectEvents закрывает потоки событий пользователя, чьи токены только
+// что отозваны.
+func (h *Handler) disconnectEvents(userID int64) {
+	if h.events != nil {
+		h.events.Disconnect(userID)
+	}
+}
@@ -0,0 +1,69 @@
+package http
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Bekw/go-practicum-diploma/internal/events"
+	"github.com/Bekw/go-practicum-diploma/internal/storage"
+)
+
+// Клиент, отставший больше чем на страницу, должен получить все
+// пропущенные события подряд.
+func TestEventsReplayPagesThroughBacklog(t *testing.T) {
+	const total = 2*sseReplayPage + 7
+
+	store := newFakeStore()
+	for id := int64(1); id <= total; id++ {
+		store.events = append(store.events, storage.UserEvent{
+			ID: id, UserID: fakeUserID, Type: storage.UserEventOrder,
+			Payload: json.RawMessage(`{}`), CreatedAt: fakeTime,
+		})
+	}
+	srv := httptest.NewServer(NewRouter(store, events.NewBroker(nil), Options{}))
+	t.Cleanup(srv.Close)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req := userRequest(t, http.MethodGet, srv.URL+"/api/user/events", "", "")
+	req = req.WithContext(ctx)
+	req.RequestURI = ""
+	req.Header.Set("Last-Event-ID", "3")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+
+	want := int64(4)
+	sc := bufio.NewScanner(resp.Body)
+	for want <= total && sc.Scan() {
+		v, ok := strings.CutPrefix(sc.Text(), "id: ")
+		if !ok {
+			continue
+		}
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != want {
+			t.Fatalf("got event %d, want %d", id, want)
+		}
+		want++
+	}
+	if want <= total {
+		t.Fatalf("stream stopped before event %d: %v", want, sc.Err())
+	}
+}
@@ -0,0 +1,53 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/Bekw/go-practicum-diploma/internal/accrual"
+)
+
+const (
+	readinessOK          = "ok"
+	readinessDegraded    = "degraded"
+	readinessUnavailable = "unavailable"
+)
+
+type readinessResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// handleReady — проверка готовности. Недоступная БД даёт 503; разомкнутый
+// выключатель системы начислений — 200 со статусом degraded: API
+// работает, только начисления откладываются.
+func (h *Handler) handleReady(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+
+	resp := readinessResponse{Status: readinessOK, Checks: map[string]string{}}
+	status := http.StatusOK
+
+	if err := h.store.Ping(ctx); err != nil {
+		resp.Checks["database"] = readinessUnavailable
+		resp.Status = readinessUnavailable
+		status = http.StatusServiceUnavailable
+	} else {
+		resp.Checks["database"] = readinessOK
+	}
+
+	if h.opts.AccrualBreaker != nil {
+		state := h.opts.AccrualBreaker.State()
+		resp.Checks["accrual"] = state.String()
+		if state == accrual.BreakerOpen && resp.Status == readinessOK {
+			resp.Status = readinessDegraded
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(resp)
+}
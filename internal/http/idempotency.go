@@ -0,0 +1,120 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/Bekw/go-practicum-diploma/internal/storage"
+)
+
+const (
+	idempotencyKeyHeader = "Idempotency-Key"
+	maxIdempotencyKeyLen = 255
+)
+
+// responseRecorder пишет ответ клиенту и одновременно запоминает его.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+	rr.status = status
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	if rr.status == 0 {
+		rr.status = http.StatusOK
+	}
+	rr.body.Write(b)
+	return rr.ResponseWriter.Write(b)
+}
+
+// idempotencyMiddleware сохраняет ответ на запрос с заголовком
+// Idempotency-Key и отдаёт его же на повторы с тем же ключом и телом.
+// Запросы без заголовка проходят как есть. Ответы 5xx не сохраняются,
+// чтобы клиент мог повторить запрос. Ответ хранится Options.IdempotencyTTL
+// с первого запроса; позже ключ считается новым.
+func (h *Handler) idempotencyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLen {
+			writeProblem(w, r, http.StatusBadRequest, codeInvalidIdempotencyKey, "Idempotency-Key is too long")
+			return
+		}
+
+		userID := getUserID(r.Context())
+		if userID == 0 {
+			writeUnauthorized(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeProblem(w, r, http.StatusBadRequest, codeBadRequest, "failed to read request body")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		sum := sha256.Sum256(append([]byte(r.Method+" "+r.URL.Path+"\n"), body...))
+		hash := hex.EncodeToString(sum[:])
+
+		ctx := r.Context()
+		existing, reserved, err := h.store.ReserveIdempotencyKey(ctx, userID, key, hash, h.opts.IdempotencyTTL)
+		if err != nil {
+			writeInternalError(w, r)
+			return
+		}
+		if !reserved {
+			replayIdempotent(w, r, existing, hash)
+			return
+		}
+
+		rec := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		// запрос уже выполнен, поэтому сохраняем результат даже при отмене клиента
+		saveCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+		defer cancel()
+
+		if rec.status == 0 || rec.status >= http.StatusInternalServerError {
+			_ = h.store.ReleaseIdempotencyKey(saveCtx, userID, key)
+			return
+		}
+		_ = h.store.CompleteIdempotencyKey(saveCtx, userID, key, storage.IdempotentResponse{
+			RequestHash: hash,
+			StatusCode:  rec.status,
+			ContentType: rec.Header().Get("Content-Type"),
+			Body:        rec.body.Bytes(),
+		})
+	})
+}
+
+func replayIdempotent(w http.ResponseWriter, r *http.Request, saved *storage.IdempotentResponse, hash string) {
+	if saved.RequestHash != hash {
+		writeProblem(w, r, http.StatusUnprocessableEntity, codeIdempotencyKeyReused, "Idempotency-Key was used with a different request")
+		return
+	}
+	if saved.StatusCode == 0 {
+		writeProblem(w, r, http.StatusConflict, codeIdempotencyKeyInProgress, "request with this Idempotency-Key is still in progress")
+		return
+	}
+
+	if saved.ContentType != "" {
+		w.Header().Set("Content-Type", saved.ContentType)
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(saved.StatusCode)
+	_, _ = w.Write(saved.Body)
+}
@@ -0,0 +1,139 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/Bekw/go-practicum-diploma/internal/auth"
+	"github.com/Bekw/go-practicum-diploma/internal/storage"
+)
+
+const fakePassword = "secret"
+
+// fakeRouter — роутер на fakeStore; у пользователя fakeUserID пароль
+// fakePassword.
+func fakeRouter(t *testing.T) http.Handler {
+	t.Helper()
+
+	store := newFakeStore()
+	hash, err := bcrypt.GenerateFromPassword([]byte(fakePassword), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.users[fakeUserID].Password = string(hash)
+
+	return NewRouter(store, nil, Options{
+		AdminToken:            testAdminToken,
+		AccrualCallbackSecret: testCallbackSecret,
+	})
+}
+
+// userRequest — запрос от имени fakeUserID.
+func userRequest(t *testing.T, method, target, contentType, body string) *http.Request {
+	t.Helper()
+
+	token, err := auth.GenerateToken(fakeUserID, storage.RoleUser, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := jsonRequest(method, target, body)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.AddCookie(&http.Cookie{Name: auth.CookieName(), Value: token})
+	return req
+}
+
+// adminRequest — запрос с сервисным токеном администратора.
+func adminRequest(method, target, body string) *http.Request {
+	req := jsonRequest(method, target, body)
+	req.Header.Set(adminTokenHeader, testAdminToken)
+	return req
+}
+
+// TestOpenAPISuccessResponses проверяет по спецификации успешные ответы
+// основных операций: хранилище подменено fakeStore с заполненными
+// необязательными полями, чтобы схемы проверялись целиком.
+func TestOpenAPISuccessResponses(t *testing.T) {
+	doc := loadSpec(t)
+	spec, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		t.Fatalf("spec router: %v", err)
+	}
+
+	user := func(method, target, contentType, body string) func(*testing.T) *http.Request {
+		return func(t *testing.T) *http.Request { return userRequest(t, method, target, contentType, body) }
+	}
+	admin := func(method, target, body string) func(*testing.T) *http.Request {
+		return func(*testing.T) *http.Request { return adminRequest(method, target, body) }
+	}
+
+	tests := []struct {
+		name   string
+		req    func(t *testing.T) *http.Request
+		status int
+	}{
+		{"readyz", func(*testing.T) *http.Request { return httptest.NewRequest(http.MethodGet, "/readyz", nil) }, http.StatusOK},
+		{
+			"register",
+			func(*testing.T) *http.Request {
+				return jsonRequest(http.MethodPost, "/api/user/register", `{"login":"carol","password":"secret"}`)
+			},
+			http.StatusOK,
+		},
+		{
+			"login",
+			func(*testing.T) *http.Request {
+				return jsonRequest(http.MethodPost, "/api/user/login", `{"login":"alice","password":"`+fakePassword+`"}`)
+			},
+			http.StatusOK,
+		},
+		{"upload order", user(http.MethodPost, "/api/user/orders", "text/plain", "79927398713"), http.StatusAccepted},
+		{"upload batch", user(http.MethodPost, "/api/user/orders/batch", "application/json", `["79927398713","1"]`), http.StatusOK},
+		{"orders", user(http.MethodGet, "/api/user/orders", "", ""), http.StatusOK},
+		{"orders oldest first", user(http.MethodGet, "/api/user/orders?sort=asc&limit=10", "", ""), http.StatusOK},
+		{"order", user(http.MethodGet, "/api/user/orders/"+fakeOrderNumber, "", ""), http.StatusOK},
+		{"balance", user(http.MethodGet, "/api/user/balance", "", ""), http.StatusOK},
+		{"withdraw", user(http.MethodPost, "/api/user/balance/withdraw", "application/json", `{"order":"2377225624","sum":10}`), http.StatusOK},
+		{"withdrawals", user(http.MethodGet, "/api/user/withdrawals", "", ""), http.StatusOK},
+		{"export", user(http.MethodGet, "/api/user/export", "", ""), http.StatusOK},
+		{"export zip", user(http.MethodGet, "/api/user/export?format=zip", "", ""), http.StatusOK},
+		{"delete account", user(http.MethodDelete, "/api/user", "application/json", `{"password":"`+fakePassword+`"}`), http.StatusNoContent},
+
+		{"admin find user", admin(http.MethodGet, "/api/admin/users?login=alice", ""), http.StatusOK},
+		{"admin user", admin(http.MethodGet, "/api/admin/users/2", ""), http.StatusOK},
+		{"admin user orders", admin(http.MethodGet, "/api/admin/users/1/orders", ""), http.StatusOK},
+		{"admin user withdrawals", admin(http.MethodGet, "/api/admin/users/1/withdrawals", ""), http.StatusOK},
+		{"admin user balance", admin(http.MethodGet, "/api/admin/users/1/balance", ""), http.StatusOK},
+		{"admin adjust balance", admin(http.MethodPost, "/api/admin/users/1/balance/adjustments", `{"amount":-5,"reason":"goodwill"}`), http.StatusCreated},
+		{"admin lock", admin(http.MethodPost, "/api/admin/users/1/lock", `{"reason":"fraud"}`), http.StatusOK},
+		{"admin unlock", admin(http.MethodPost, "/api/admin/users/2/unlock", ""), http.StatusOK},
+		{"admin role", admin(http.MethodPut, "/api/admin/users/1/role", `{"role":"support"}`), http.StatusOK},
+		{"admin reverse withdrawal", admin(http.MethodPost, "/api/admin/withdrawals/1/reverse", `{"reason":"refund"}`), http.StatusOK},
+		{"admin requeue order", admin(http.MethodPost, "/api/admin/orders/9278923470/requeue", ""), http.StatusOK},
+		{"admin create webhook", admin(http.MethodPost, "/api/admin/webhooks", `{"url":"https://example.com/hook","events":["order.processed"]}`), http.StatusCreated},
+		{"admin webhooks", admin(http.MethodGet, "/api/admin/webhooks", ""), http.StatusOK},
+		{"admin delete webhook", admin(http.MethodDelete, "/api/admin/webhooks/1", ""), http.StatusNoContent},
+		{"admin webhook deliveries", admin(http.MethodGet, "/api/admin/webhooks/1/deliveries", ""), http.StatusOK},
+		{"admin audit", admin(http.MethodGet, "/api/admin/audit?user_id=2", ""), http.StatusOK},
+		{"admin audit verify", admin(http.MethodGet, "/api/admin/audit/verify", ""), http.StatusOK},
+		{
+			"accrual callback",
+			func(t *testing.T) *http.Request {
+				return signedCallback(t, `{"order":"`+fakeOrderNumber+`","status":"PROCESSED","accrual":500}`)
+			},
+			http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// у каждого случая своё хранилище: запросы меняют состояние
+			checkContract(t, spec, fakeRouter(t), tt.req, tt.status, true)
+		})
+	}
+}
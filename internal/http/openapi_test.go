@@ -0,0 +1,323 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	testAdminToken     = "admin-token"
+	testCallbackSecret = "callback-secret"
+)
+
+func loadSpec(t *testing.T) *openapi3.T {
+	t.Helper()
+
+	doc, err := openapi3.NewLoader().LoadFromData(openAPISpec)
+	if err != nil {
+		t.Fatalf("load spec: %v", err)
+	}
+	if err := doc.Validate(context.Background()); err != nil {
+		t.Fatalf("invalid spec: %v", err)
+	}
+	return doc
+}
+
+// testRouter — роутер со всеми необязательными маршрутами. Хранилище не
+// нужно: тесты ходят только по путям, которые отвечают до обращения к БД.
+func testRouter() http.Handler {
+	return NewRouter(nil, nil, Options{
+		AdminToken:            testAdminToken,
+		AccrualCallbackSecret: testCallbackSecret,
+	})
+}
+
+func TestOpenAPIMatchesRoutes(t *testing.T) {
+	doc := loadSpec(t)
+
+	registered := map[string]bool{}
+	err := chi.Walk(testRouter().(chi.Routes), func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		registered[method+" "+route] = true
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("walk routes: %v", err)
+	}
+
+	documented := map[string]bool{}
+	for path, item := range doc.Paths.Map() {
+		for method := range item.Operations() {
+			documented[method+" "+path] = true
+		}
+	}
+
+	for route := range registered {
+		if !documented[route] {
+			t.Errorf("route %s is not described in openapi.json", route)
+		}
+	}
+	for route := range documented {
+		if !registered[route] {
+			t.Errorf("openapi.json describes %s, but the router does not serve it", route)
+		}
+	}
+}
+
+func TestOpenAPIRequiredRoles(t *testing.T) {
+	doc := loadSpec(t)
+
+	admin := map[string][]string{}
+	for _, rt := range (&Handler{}).adminRoutes() {
+		admin[rt.method+" "+rt.pattern] = rt.roles
+	}
+
+	for path, item := range doc.Paths.Map() {
+		for method, op := range item.Operations() {
+			route := method + " " + path
+			declared, err := requiredRoles(op)
+			if err != nil {
+				t.Errorf("%s: %v", route, err)
+				continue
+			}
+
+			want, ok := admin[route]
+			if !ok {
+				if declared != nil {
+					t.Errorf("%s: x-required-roles %v on a route without requireRole", route, declared)
+				}
+				continue
+			}
+			if !sameRoles(declared, want) {
+				t.Errorf("%s: x-required-roles %v, router requires %v", route, declared, want)
+			}
+		}
+	}
+}
+
+func requiredRoles(op *openapi3.Operation) ([]string, error) {
+	raw, ok := op.Extensions["x-required-roles"]
+	if !ok {
+		return nil, nil
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	var roles []string
+	if err := json.Unmarshal(data, &roles); err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+func sameRoles(a, b []string) bool {
+	a, b = slices.Clone(a), slices.Clone(b)
+	slices.Sort(a)
+	slices.Sort(b)
+	return slices.Equal(a, b)
+}
+
+func signedCallback(t *testing.T, body string) *http.Request {
+	t.Helper()
+
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(testCallbackSecret))
+	mac.Write([]byte(ts + "." + body))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/internal/accrual", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(accrualTimestampHeader, ts)
+	req.Header.Set(accrualSignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	return req
+}
+
+func jsonRequest(method, target, body string) *http.Request {
+	req := httptest.NewRequest(method, target, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	return req
+}
+
+func TestOpenAPIResponses(t *testing.T) {
+	doc := loadSpec(t)
+	router, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		t.Fatalf("spec router: %v", err)
+	}
+	h := testRouter()
+
+	tests := []struct {
+		name   string
+		req    func(t *testing.T) *http.Request
+		status int
+		// validRequest — запрос должен проходить валидацию по спецификации.
+		validRequest bool
+	}{
+		{
+			name:         "spec",
+			req:          func(*testing.T) *http.Request { return httptest.NewRequest(http.MethodGet, "/api/openapi.json", nil) },
+			status:       http.StatusOK,
+			validRequest: true,
+		},
+		{
+			name:   "register with malformed body",
+			req:    func(*testing.T) *http.Request { return jsonRequest(http.MethodPost, "/api/user/register", "{") },
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "login without password",
+			req: func(*testing.T) *http.Request {
+				return jsonRequest(http.MethodPost, "/api/user/login", `{"login":"a"}`)
+			},
+			status: http.StatusBadRequest,
+		},
+		{
+			name:         "orders without cookie",
+			req:          func(*testing.T) *http.Request { return httptest.NewRequest(http.MethodGet, "/api/user/orders", nil) },
+			status:       http.StatusUnauthorized,
+			validRequest: true,
+		},
+		{
+			name:         "export without cookie",
+			req:          func(*testing.T) *http.Request { return httptest.NewRequest(http.MethodGet, "/api/user/export", nil) },
+			status:       http.StatusUnauthorized,
+			validRequest: true,
+		},
+		{
+			name: "admin with wrong token",
+			req: func(*testing.T) *http.Request {
+				req := httptest.NewRequest(http.MethodGet, "/api/admin/webhooks", nil)
+				req.Header.Set(adminTokenHeader, "wrong")
+				return req
+			},
+			status:       http.StatusUnauthorized,
+			validRequest: true,
+		},
+		{
+			name: "admin with invalid user id",
+			req: func(*testing.T) *http.Request {
+				req := httptest.NewRequest(http.MethodGet, "/api/admin/audit?user_id=x", nil)
+				req.Header.Set(adminTokenHeader, testAdminToken)
+				return req
+			},
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "audit verify with half an anchor",
+			req: func(*testing.T) *http.Request {
+				req := httptest.NewRequest(http.MethodGet, "/api/admin/audit/verify?head_id=5", nil)
+				req.Header.Set(adminTokenHeader, testAdminToken)
+				return req
+			},
+			status:       http.StatusBadRequest,
+			validRequest: true,
+		},
+		{
+			name: "accrual callback with bad signature",
+			req: func(t *testing.T) *http.Request {
+				req := signedCallback(t, "[]")
+				req.Header.Set(accrualSignatureHeader, "sha256=00")
+				return req
+			},
+			status:       http.StatusUnauthorized,
+			validRequest: true,
+		},
+		{
+			name:         "accrual callback with empty batch",
+			req:          func(t *testing.T) *http.Request { return signedCallback(t, "[]") },
+			status:       http.StatusOK,
+			validRequest: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkContract(t, router, h, tt.req, tt.status, tt.validRequest)
+		})
+	}
+}
+
+// checkContract выполняет запрос req на h, сверяет статус и проверяет ответ
+// (и, если validRequest, сам запрос) по спецификации. req вызывается
+// дважды: тело запроса читается и обработчиком, и валидатором.
+func checkContract(t *testing.T, spec routers.Router, h http.Handler, req func(*testing.T) *http.Request, status int, validRequest bool) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req(t))
+	if rec.Code != status {
+		t.Fatalf("status %d, want %d; body %s", rec.Code, status, rec.Body)
+	}
+
+	r := req(t)
+	route, params, err := spec.FindRoute(r)
+	if err != nil {
+		t.Fatalf("find route: %v", err)
+	}
+	in := &openapi3filter.RequestValidationInput{
+		Request:    r,
+		PathParams: params,
+		Route:      route,
+		Options:    &openapi3filter.Options{AuthenticationFunc: openapi3filter.NoopAuthenticationFunc},
+	}
+	if validRequest {
+		if err := openapi3filter.ValidateRequest(r.Context(), in); err != nil {
+			t.Errorf("request does not match spec: %v", err)
+		}
+	}
+	validateResponse(t, in, rec)
+}
+
+// TestOpenAPIProblemForUnknownRoute проверяет ответ, которого нет ни в одной
+// операции: тело должно соответствовать схеме Problem.
+func TestOpenAPIProblemForUnknownRoute(t *testing.T) {
+	doc := loadSpec(t)
+
+	rec := httptest.NewRecorder()
+	testRouter().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/nope", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("status %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/problem+json" {
+		t.Errorf("content type %q", ct)
+	}
+
+	var body any
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if err := doc.Components.Schemas["Problem"].Value.VisitJSON(body); err != nil {
+		t.Errorf("body does not match Problem: %v", err)
+	}
+}
+
+func validateResponse(t *testing.T, in *openapi3filter.RequestValidationInput, rec *httptest.ResponseRecorder) {
+	t.Helper()
+
+	err := openapi3filter.ValidateResponse(context.Background(), &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: in,
+		Status:                 rec.Code,
+		Header:                 rec.Header(),
+		Body:                   io.NopCloser(bytes.NewReader(rec.Body.Bytes())),
+		Options:                &openapi3filter.Options{IncludeResponseStatus: true},
+	})
+	if err != nil {
+		t.Errorf("response does not match spec: %v", err)
+	}
+}
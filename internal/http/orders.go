@@ -2,107 +2,193 @@ package http
 
 import (
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
 	"strings"
 	"time"
 
+	"github.com/go-chi/chi/v5"
+
 	"github.com/Bekw/go-practicum-diploma/internal/storage"
 )
 
 type orderResponse struct {
-	Number     string   `json:"number"`
-	Status     string   `json:"status"`
-	Accrual    *float64 `json:"accrual,omitempty"`
-	UploadedAt string   `json:"uploaded_at"`
+	Number       string   `json:"number"`
+	Status       string   `json:"status"`
+	StatusDetail string   `json:"status_detail,omitempty"`
+	Accrual      *float64 `json:"accrual,omitempty"`
+	UploadedAt   string   `json:"uploaded_at"`
 }
 
+// stalledOrderDetail поясняет пользователю статус STALLED.
+const stalledOrderDetail = "the accrual system has not confirmed this order; contact support to have it rechecked"
+
 func (h *Handler) handlePostOrder(w http.ResponseWriter, r *http.Request) {
 	userID := getUserID(r.Context())
 	if userID == 0 {
-		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		writeUnauthorized(w, r)
 		return
 	}
 
 	ct := r.Header.Get("Content-Type")
 	if !strings.HasPrefix(ct, "text/plain") {
-		http.Error(w, "bad request", http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, codeInvalidContentType, "Content-Type must be text/plain")
 		return
 	}
 
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, "bad request", http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, codeBadRequest, "failed to read request body")
 		return
 	}
 	number := strings.TrimSpace(string(body))
 	if number == "" {
-		http.Error(w, "bad request", http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, codeEmptyOrderNumber, "order number is required")
 		return
 	}
 
 	if !isLuhnValid(number) {
-		w.WriteHeader(http.StatusUnprocessableEntity)
+		writeProblem(w, r, http.StatusUnprocessableEntity, codeInvalidOrderNumber, "order number fails the Luhn check")
 		return
 	}
 
 	ctx := r.Context()
 
-	existing, err := h.store.GetOrderByNumber(ctx, number)
-	if err != nil && err != storage.ErrOrderNotFound {
-		http.Error(w, "internal error", http.StatusInternalServerError)
+	res, err := h.store.RegisterOrder(ctx, userID, number)
+	if err != nil {
+		writeInternalError(w, r)
 		return
 	}
 
-	if existing != nil {
-		if existing.UserID == userID {
-			w.WriteHeader(http.StatusOK)
-		} else {
-			http.Error(w, "order belongs to another user", http.StatusConflict)
-		}
+	switch res {
+	case storage.OrderUploadAlreadyUploaded:
+		w.WriteHeader(http.StatusOK)
+	case storage.OrderUploadOwnedByOtherUser:
+		writeProblem(w, r, http.StatusConflict, codeOrderOwnedByOtherUser, "order was uploaded by another user")
+	default:
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+func (h *Handler) handleGetOrders(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r.Context())
+	if userID == 0 {
+		writeUnauthorized(w, r)
+		return
+	}
+
+	h.writeOrders(w, r, userID)
+}
+
+// writeOrders отдаёт страницу заказов пользователя по параметрам запроса.
+func (h *Handler) writeOrders(w http.ResponseWriter, r *http.Request, userID int64) {
+	ctx := r.Context()
+
+	page, err := parsePageParams(r.URL.Query())
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, codeInvalidQuery, err.Error())
 		return
 	}
+	filter, err := parseOrderFilter(r.URL.Query())
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, codeInvalidQuery, err.Error())
+		return
+	}
+	filter.After = page.after
+	filter.Asc = page.asc
+	filter.Limit = page.limit
 
-	if err := h.store.CreateOrder(ctx, userID, number); err != nil {
-		http.Error(w, "internal error", http.StatusInternalServerError)
+	orders, next, err := h.store.ListOrdersByUserPage(ctx, userID, filter)
+	if err != nil {
+		writeInternalError(w, r)
 		return
 	}
+	setNextPage(w, r, next, page.limit)
+
+	resp := make([]orderResponse, len(orders))
+	for i, o := range orders {
+		resp[i] = newOrderResponse(&o)
+	}
 
-	w.WriteHeader(http.StatusAccepted)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(resp)
 }
 
-func (h *Handler) handleGetOrders(w http.ResponseWriter, r *http.Request) {
+func newOrderResponse(o *storage.Order) orderResponse {
+	var accrual *float64
+	if o.Accrual.Valid {
+		v := o.Accrual.Float64
+		accrual = &v
+	}
+
+	resp := orderResponse{
+		Number:     o.Number,
+		Status:     o.Status,
+		Accrual:    accrual,
+		UploadedAt: o.UploadedAt.Format(time.RFC3339),
+	}
+	if o.Status == storage.OrderStatusStalled {
+		resp.StatusDetail = stalledOrderDetail
+	}
+	return resp
+}
+
+type orderStatusChangeResponse struct {
+	Status    string   `json:"status"`
+	Accrual   *float64 `json:"accrual,omitempty"`
+	ChangedAt string   `json:"changed_at"`
+}
+
+type orderDetailsResponse struct {
+	orderResponse
+	History []orderStatusChangeResponse `json:"history"`
+}
+
+// handleGetOrder отдаёт заказ с историей статусов. Чужой и несуществующий
+// номер неразличимы для клиента: оба дают 404. Поддерживает If-None-Match.
+func (h *Handler) handleGetOrder(w http.ResponseWriter, r *http.Request) {
 	userID := getUserID(r.Context())
 	if userID == 0 {
-		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		writeUnauthorized(w, r)
 		return
 	}
 
 	ctx := r.Context()
 
-	orders, err := h.store.ListOrdersByUser(ctx, userID)
+	o, err := h.store.GetOrderByNumber(ctx, chi.URLParam(r, "number"))
+	if err != nil && !errors.Is(err, storage.ErrOrderNotFound) {
+		writeInternalError(w, r)
+		return
+	}
+	if o == nil || o.UserID != userID {
+		writeProblem(w, r, http.StatusNotFound, codeOrderNotFound, "order not found")
+		return
+	}
+
+	history, err := h.store.GetOrderHistory(ctx, o.ID)
 	if err != nil {
-		http.Error(w, "internal error", http.StatusInternalServerError)
+		writeInternalError(w, r)
 		return
 	}
 
-	resp := make([]orderResponse, len(orders))
-	for i, o := range orders {
+	resp := orderDetailsResponse{
+		orderResponse: newOrderResponse(o),
+		History:       make([]orderStatusChangeResponse, len(history)),
+	}
+	for i, c := range history {
 		var accrual *float64
-		if o.Accrual.Valid {
-			v := o.Accrual.Float64
+		if c.Accrual.Valid {
+			v := c.Accrual.Float64
 			accrual = &v
 		}
-
-		resp[i] = orderResponse{
-			Number:     o.Number,
-			Status:     o.Status,
-			Accrual:    accrual,
-			UploadedAt: o.UploadedAt.Format(time.RFC3339),
+		resp.History[i] = orderStatusChangeResponse{
+			Status:    c.Status,
+			Accrual:   accrual,
+			ChangedAt: c.ChangedAt.Format(time.RFC3339),
 		}
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	_ = json.NewEncoder(w).Encode(resp)
+	writeJSONWithETag(w, r, resp)
 }
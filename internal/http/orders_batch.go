@@ -0,0 +1,116 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/Bekw/go-practicum-diploma/internal/storage"
+)
+
+const (
+	maxBatchOrders    = 1000
+	maxBatchBodyBytes = 1 << 20
+
+	orderResultInvalid = "invalid"
+)
+
+type orderBatchResult struct {
+	Number string `json:"number"`
+	Result string `json:"result"`
+}
+
+// handlePostOrdersBatch принимает JSON-массив номеров или text/plain по
+// одному номеру на строку. Невалидные по Луну номера не сохраняются,
+// остальные регистрируются одной транзакцией.
+func (h *Handler) handlePostOrdersBatch(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r.Context())
+	if userID == 0 {
+		writeUnauthorized(w, r)
+		return
+	}
+
+	body, ok := readLimitedBody(w, r, maxBatchBodyBytes)
+	if !ok {
+		return
+	}
+
+	var numbers []string
+	ct := r.Header.Get("Content-Type")
+	switch {
+	case strings.HasPrefix(ct, "application/json"):
+		if err := json.Unmarshal(body, &numbers); err != nil {
+			writeProblem(w, r, http.StatusBadRequest, codeInvalidJSON, "request body must be a JSON array of order numbers")
+			return
+		}
+	case strings.HasPrefix(ct, "text/plain"):
+		numbers = strings.Split(string(body), "\n")
+	default:
+		writeProblem(w, r, http.StatusBadRequest, codeInvalidContentType, "Content-Type must be application/json or text/plain")
+		return
+	}
+
+	results := make([]orderBatchResult, 0, len(numbers))
+	var valid []string
+	for _, n := range numbers {
+		n = strings.TrimSpace(n)
+		if n == "" {
+			continue
+		}
+		results = append(results, orderBatchResult{Number: n})
+		if isLuhnValid(n) {
+			valid = append(valid, n)
+		}
+	}
+
+	if len(results) == 0 {
+		writeProblem(w, r, http.StatusBadRequest, codeEmptyOrderNumber, "no order numbers in request")
+		return
+	}
+	if len(results) > maxBatchOrders {
+		writeProblem(w, r, http.StatusRequestEntityTooLarge, codeBatchTooLarge, "too many order numbers in one request")
+		return
+	}
+
+	var stored []storage.OrderUploadResult
+	if len(valid) > 0 {
+		var err error
+		stored, err = h.store.CreateOrdersBatch(r.Context(), userID, valid)
+		if err != nil {
+			writeInternalError(w, r)
+			return
+		}
+	}
+
+	j := 0
+	for i := range results {
+		if !isLuhnValid(results[i].Number) {
+			results[i].Result = orderResultInvalid
+			continue
+		}
+		results[i].Result = string(stored[j])
+		j++
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(results)
+}
+
+// readLimitedBody читает тело не длиннее limit байт. Превышение лимита —
+// 413, прочие ошибки чтения — 400; в обоих случаях ответ уже записан.
+func readLimitedBody(w http.ResponseWriter, r *http.Request, limit int64) ([]byte, bool) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeProblem(w, r, http.StatusRequestEntityTooLarge, codeBatchTooLarge, "request body is too large")
+		} else {
+			writeProblem(w, r, http.StatusBadRequest, codeBadRequest, "failed to read request body")
+		}
+		return nil, false
+	}
+	return body, true
+}
@@ -0,0 +1,39 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) { return 0, errors.New("connection reset") }
+
+func TestReadLimitedBody(t *testing.T) {
+	tests := []struct {
+		name   string
+		req    *http.Request
+		ok     bool
+		status int
+	}{
+		{name: "fits", req: httptest.NewRequest(http.MethodPost, "/", strings.NewReader("12345")), ok: true},
+		{name: "too large", req: httptest.NewRequest(http.MethodPost, "/", strings.NewReader("123456")), status: http.StatusRequestEntityTooLarge},
+		{name: "read error", req: httptest.NewRequest(http.MethodPost, "/", failingReader{}), status: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			_, ok := readLimitedBody(rec, tt.req, 5)
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if !ok && rec.Code != tt.status {
+				t.Errorf("status %d, want %d", rec.Code, tt.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package http
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Bekw/go-practicum-diploma/internal/storage"
+)
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 1000
+)
+
+var errBadQuery = errors.New("bad query")
+
+// pageParams — разобранные limit/cursor/sort. Если ни limit, ни cursor не
+// переданы, limit == 0 и список отдаётся целиком, как раньше. asc —
+// сортировка от старых к новым (sort=asc); по умолчанию от новых к старым.
+type pageParams struct {
+	limit int
+	after *storage.Cursor
+	asc   bool
+}
+
+func parsePageParams(q url.Values) (pageParams, error) {
+	var p pageParams
+
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxPageLimit {
+			return p, fmt.Errorf("%w: limit must be between 1 and %d", errBadQuery, maxPageLimit)
+		}
+		p.limit = n
+	}
+
+	if v := q.Get("cursor"); v != "" {
+		c, err := decodeCursor(v)
+		if err != nil {
+			return p, fmt.Errorf("%w: malformed cursor", errBadQuery)
+		}
+		p.after = c
+		p.asc = c.Asc
+		if p.limit == 0 {
+			p.limit = defaultPageLimit
+		}
+	}
+
+	// направление записано в курсоре; sort рядом с ним допустим, только
+	// если совпадает (setNextPage сохраняет параметры исходного запроса)
+	switch v := q.Get("sort"); v {
+	case "":
+	case "asc", "desc":
+		asc := v == "asc"
+		if p.after != nil && p.after.Asc != asc {
+			return p, fmt.Errorf("%w: sort does not match the cursor", errBadQuery)
+		}
+		p.asc = asc
+	default:
+		return p, fmt.Errorf("%w: sort must be asc or desc", errBadQuery)
+	}
+
+	return p, nil
+}
+
+// encodeCursor кодирует "время|id", для sort=asc — "время|id|asc".
+func encodeCursor(c *storage.Cursor) string {
+	raw := c.At.UTC().Format(time.RFC3339Nano) + "|" + strconv.FormatInt(c.ID, 10)
+	if c.Asc {
+		raw += "|asc"
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(s string) (*storage.Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	at, id, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return nil, errors.New("bad cursor format")
+	}
+	id, dir, hasDir := strings.Cut(id, "|")
+	if hasDir && dir != "asc" {
+		return nil, errors.New("bad cursor direction")
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, at)
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &storage.Cursor{At: t, ID: n, Asc: hasDir}, nil
+}
+
+// setNextPage выставляет Link rel="next" и X-Next-Cursor, сохраняя
+// остальные параметры исходного запроса (фильтры, limit).
+func setNextPage(w http.ResponseWriter, r *http.Request, next *storage.Cursor, limit int) {
+	if next == nil {
+		return
+	}
+	cursor := encodeCursor(next)
+
+	q := r.URL.Query()
+	q.Set("cursor", cursor)
+	q.Set("limit", strconv.Itoa(limit))
+	u := url.URL{Path: r.URL.Path, RawQuery: q.Encode()}
+
+	w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, u.String()))
+	w.Header().Set("X-Next-Cursor", cursor)
+}
+
+// parseOrderFilter разбирает status (через запятую или повторяющийся
+// параметр) и диапазон дат from/to в формате RFC3339.
+func parseOrderFilter(q url.Values) (storage.OrderFilter, error) {
+	var f storage.OrderFilter
+
+	for _, v := range q["status"] {
+		for _, st := range strings.Split(v, ",") {
+			st = strings.ToUpper(strings.TrimSpace(st))
+			if st == "" {
+				continue
+			}
+			if !isKnownOrderStatus(st) {
+				return f, fmt.Errorf("%w: unknown status %q", errBadQuery, st)
+			}
+			f.Statuses = append(f.Statuses, st)
+		}
+	}
+
+	var err error
+	if f.From, err = parseTimeParam(q, "from"); err != nil {
+		return f, err
+	}
+	if f.To, err = parseTimeParam(q, "to"); err != nil {
+		return f, err
+	}
+	if !f.From.IsZero() && !f.To.IsZero() && !f.From.Before(f.To) {
+		return f, fmt.Errorf("%w: from must be before to", errBadQuery)
+	}
+
+	return f, nil
+}
+
+func parseTimeParam(q url.Values, name string) (time.Time, error) {
+	v := q.Get(name)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %s must be RFC3339", errBadQuery, name)
+	}
+	return t, nil
+}
+
+func isKnownOrderStatus(s string) bool {
+	switch s {
+	case storage.OrderStatusNew, storage.OrderStatusProcessing, storage.OrderStatusInvalid, storage.OrderStatusProcessed,
+		storage.OrderStatusStalled:
+		return true
+	}
+	return false
+}
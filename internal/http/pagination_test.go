@@ -0,0 +1,94 @@
+package http
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/Bekw/go-practicum-diploma/internal/storage"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	tests := []storage.Cursor{
+		{At: time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC), ID: 1},
+		{At: time.Date(2024, 3, 1, 12, 30, 0, 123456000, time.UTC), ID: 42},
+		{At: time.Date(2024, 3, 1, 15, 30, 0, 0, time.FixedZone("MSK", 3*3600)), ID: 1 << 40},
+		{At: time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC), ID: 5, Asc: true},
+	}
+
+	for _, c := range tests {
+		got, err := decodeCursor(encodeCursor(&c))
+		if err != nil {
+			t.Fatalf("decode %v: %v", c, err)
+		}
+		if !got.At.Equal(c.At) || got.ID != c.ID || got.Asc != c.Asc {
+			t.Errorf("round trip %v: got %v", c, *got)
+		}
+	}
+}
+
+func TestDecodeCursorMalformed(t *testing.T) {
+	raw := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+
+	tests := map[string]string{
+		"not base64":    "!!!",
+		"no separator":  raw("2024-03-01T12:30:00Z"),
+		"bad time":      raw("yesterday|5"),
+		"bad id":        raw("2024-03-01T12:30:00Z|five"),
+		"empty":         raw(""),
+		"bad direction": raw("2024-03-01T12:30:00Z|5|up"),
+	}
+	for name, s := range tests {
+		if _, err := decodeCursor(s); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestParsePageParams(t *testing.T) {
+	c := encodeCursor(&storage.Cursor{At: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), ID: 7})
+	ascCursor := encodeCursor(&storage.Cursor{At: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), ID: 7, Asc: true})
+
+	tests := []struct {
+		name      string
+		query     string
+		wantLimit int
+		wantAfter bool
+		wantAsc   bool
+		wantErr   bool
+	}{
+		{name: "nothing", query: ""},
+		{name: "limit", query: "limit=10", wantLimit: 10},
+		{name: "cursor implies default limit", query: "cursor=" + c, wantLimit: defaultPageLimit, wantAfter: true},
+		{name: "zero limit", query: "limit=0", wantErr: true},
+		{name: "too large", query: "limit=1001", wantErr: true},
+		{name: "malformed cursor", query: "cursor=abc", wantErr: true},
+		{name: "sort asc", query: "sort=asc", wantAsc: true},
+		{name: "sort desc", query: "sort=desc"},
+		{name: "unknown sort", query: "sort=up", wantErr: true},
+		{name: "asc cursor", query: "cursor=" + ascCursor, wantLimit: defaultPageLimit, wantAfter: true, wantAsc: true},
+		{name: "asc cursor with sort", query: "sort=asc&cursor=" + ascCursor, wantLimit: defaultPageLimit, wantAfter: true, wantAsc: true},
+		{name: "sort against cursor", query: "sort=asc&cursor=" + c, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, _ := url.ParseQuery(tt.query)
+			p, err := parsePageParams(q)
+			if tt.wantErr {
+				if !errors.Is(err, errBadQuery) {
+					t.Fatalf("err = %v, want errBadQuery", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if p.limit != tt.wantLimit || (p.after != nil) != tt.wantAfter || p.asc != tt.wantAsc {
+				t.Errorf("got limit %d, after %v, asc %v", p.limit, p.after, p.asc)
+			}
+		})
+	}
+}
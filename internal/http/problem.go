@@ -0,0 +1,83 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Стабильные коды ошибок API. Клиенты ориентируются на них, а не на текст,
+// поэтому существующие значения менять нельзя.
+const (
+	codeBadRequest                = "bad_request"
+	codeInvalidContentType        = "invalid_content_type"
+	codeInvalidJSON               = "invalid_json"
+	codeCredentialsRequired       = "credentials_required"
+	codeLoginTaken                = "login_taken"
+	codeInvalidCredentials        = "invalid_credentials"
+	codeUnauthorized              = "unauthorized"
+	codeEmptyOrderNumber          = "empty_order_number"
+	codeInvalidOrderNumber        = "invalid_order_number"
+	codeOrderOwnedByOtherUser     = "order_owned_by_other_user"
+	codeOrderNotFound             = "order_not_found"
+	codeInvalidWithdrawalSum      = "invalid_withdrawal_sum"
+	codeInsufficientFunds         = "insufficient_funds"
+	codeInvalidQuery              = "invalid_query"
+	codeDuplicateWithdrawal       = "duplicate_withdrawal"
+	codeInvalidIdempotencyKey     = "invalid_idempotency_key"
+	codeIdempotencyKeyReused      = "idempotency_key_reused"
+	codeIdempotencyKeyInProgress  = "idempotency_key_in_progress"
+	codeReasonRequired            = "reason_required"
+	codeWithdrawalNotFound        = "withdrawal_not_found"
+	codeWithdrawalAlreadyReversed = "withdrawal_already_reversed"
+	codeInvalidWebhook            = "invalid_webhook"
+	codeWebhookNotFound           = "webhook_not_found"
+	codeInvalidSignature          = "invalid_signature"
+	codeOrderNotStalled           = "order_not_stalled"
+	codeForbidden                 = "forbidden"
+	codeAccountLocked             = "account_locked"
+	codeUserNotFound              = "user_not_found"
+	codeUserAlreadyLocked         = "user_already_locked"
+	codeUserNotLocked             = "user_not_locked"
+	codeInvalidAdjustment         = "invalid_adjustment"
+	codeInvalidRole               = "invalid_role"
+	codeNotFound                  = "not_found"
+	codeMethodNotAllowed          = "method_not_allowed"
+	codeBatchTooLarge             = "batch_too_large"
+	codeInternalError             = "internal_error"
+)
+
+const problemContentType = "application/problem+json"
+
+// problem — тело ошибки по RFC 7807 с расширением code.
+type problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	Code     string `json:"code"`
+}
+
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, code, detail string) {
+	p := problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   detail,
+		Instance: r.URL.Path,
+		Code:     code,
+	}
+
+	w.Header().Set("Content-Type", problemContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(p)
+}
+
+func writeInternalError(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, http.StatusInternalServerError, codeInternalError, "internal error")
+}
+
+func writeUnauthorized(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, http.StatusUnauthorized, codeUnauthorized, "authentication required")
+}
@@ -0,0 +1,43 @@
+package http
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/Bekw/go-practicum-diploma/internal/storage"
+)
+
+// Наборы ролей для объявления прав на маршрутах в NewRouter. Новый
+// привилегированный маршрут добавляется в adminRoutes с одним из них.
+var (
+	// rolesCustomer — все, кто может пользоваться API начисления и списания
+	// баллов.
+	rolesCustomer = []string{storage.RoleUser, storage.RoleSupport, storage.RoleAdmin, storage.RoleMerchant}
+	// rolesSupport — просмотр пользователей и операции поддержки, не
+	// затрагивающие денежные суммы.
+	rolesSupport = []string{storage.RoleSupport, storage.RoleAdmin}
+	// rolesAdmin — денежные операции, роли и настройка интеграций.
+	rolesAdmin = []string{storage.RoleAdmin}
+)
+
+// requireRole пропускает запросы, роль которых входит в roles. Ставится
+// после authMiddleware или adminMiddleware, которые кладут роль в контекст.
+func (h *Handler) requireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !slices.Contains(roles, getUserRole(r.Context())) {
+				writeProblem(w, r, http.StatusForbidden, codeForbidden, "insufficient role")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func isKnownRole(role string) bool {
+	switch role {
+	case storage.RoleUser, storage.RoleSupport, storage.RoleAdmin, storage.RoleMerchant:
+		return true
+	}
+	return false
+}
@@ -3,40 +3,134 @@ package http
 import (
 	"encoding/json"
 	"net/http"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 
+	"github.com/Bekw/go-practicum-diploma/internal/accrual"
 	"github.com/Bekw/go-practicum-diploma/internal/auth"
+	"github.com/Bekw/go-practicum-diploma/internal/events"
 	"github.com/Bekw/go-practicum-diploma/internal/storage"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type Handler struct {
-	store *storage.Storage
+	store  Store
+	events *events.Broker
+	opts   Options
 }
 
-func NewRouter(store *storage.Storage) http.Handler {
-	h := &Handler{store: store}
+// Options — необязательные части API. Пустые значения отключают
+// соответствующие маршруты.
+type Options struct {
+	// AdminToken — сервисный токен для /api/admin с правами роли admin в
+	// дополнение к пользователям с ролями support и admin. Пустое значение
+	// отключает токен.
+	AdminToken string
+	// AccrualCallbackSecret — общий секрет HMAC для приёма результатов
+	// начислений через /api/internal/accrual.
+	AccrualCallbackSecret string
+	// AccrualBreaker — выключатель клиента системы начислений; его
+	// состояние показывается в /readyz.
+	AccrualBreaker *accrual.Breaker
+	// IdempotencyTTL — сколько хранится ответ на запрос с Idempotency-Key;
+	// 0 — без срока.
+	IdempotencyTTL time.Duration
+}
+
+func NewRouter(store Store, broker *events.Broker, opts Options) http.Handler {
+	h := &Handler{store: store, events: broker, opts: opts}
 
 	r := chi.NewRouter()
+	r.Use(tracingMiddleware)
+	r.Use(auditMetaMiddleware)
+
+	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		writeProblem(w, r, http.StatusNotFound, codeNotFound, "resource not found")
+	})
+	r.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		writeProblem(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
+	})
+
+	r.Get("/api/openapi.json", handleOpenAPI)
+	r.Get("/readyz", h.handleReady)
 
 	r.Post("/api/user/register", h.handleRegister)
 	r.Post("/api/user/login", h.handleLogin)
 
 	r.Group(func(r chi.Router) {
 		r.Use(h.authMiddleware)
+		r.Use(h.requireRole(rolesCustomer...))
 
 		r.Post("/api/user/orders", h.handlePostOrder)
+		r.Post("/api/user/orders/batch", h.handlePostOrdersBatch)
 		r.Get("/api/user/orders", h.handleGetOrders)
+		r.Get("/api/user/orders/{number}", h.handleGetOrder)
 
 		r.Get("/api/user/balance", h.handleGetBalance)
-		r.Post("/api/user/balance/withdraw", h.handleWithdraw)
+		r.With(h.idempotencyMiddleware).Post("/api/user/balance/withdraw", h.handleWithdraw)
 		r.Get("/api/user/withdrawals", h.handleGetWithdrawals)
+
+		r.Get("/api/user/events", h.handleEvents)
+
+		r.Get("/api/user/export", h.handleExport)
+		r.Delete("/api/user", h.handleDeleteAccount)
 	})
 
+	r.Group(func(r chi.Router) {
+		r.Use(h.adminMiddleware)
+
+		for _, rt := range h.adminRoutes() {
+			r.With(h.requireRole(rt.roles...)).Method(rt.method, rt.pattern, rt.handler)
+		}
+	})
+
+	if opts.AccrualCallbackSecret != "" {
+		r.Group(func(r chi.Router) {
+			r.Use(h.accrualCallbackMiddleware)
+
+			r.Post("/api/internal/accrual", h.handleAccrualCallback)
+		})
+	}
+
 	return r
 }
 
+// adminRoute — маршрут /api/admin и роли, которым он доступен. Тест
+// контракта сверяет roles с x-required-roles операции в openapi.json.
+type adminRoute struct {
+	method  string
+	pattern string
+	roles   []string
+	handler http.HandlerFunc
+}
+
+func (h *Handler) adminRoutes() []adminRoute {
+	return []adminRoute{
+		{http.MethodGet, "/api/admin/users", rolesSupport, h.handleAdminFindUser},
+		{http.MethodGet, "/api/admin/users/{id}", rolesSupport, h.handleAdminGetUser},
+		{http.MethodGet, "/api/admin/users/{id}/orders", rolesSupport, h.handleAdminGetUserOrders},
+		{http.MethodGet, "/api/admin/users/{id}/withdrawals", rolesSupport, h.handleAdminGetUserWithdrawals},
+		{http.MethodGet, "/api/admin/users/{id}/balance", rolesSupport, h.handleAdminGetUserBalance},
+		{http.MethodPost, "/api/admin/users/{id}/balance/adjustments", rolesAdmin, h.handleAdminAdjustBalance},
+		{http.MethodPost, "/api/admin/users/{id}/lock", rolesSupport, h.handleAdminLockUser},
+		{http.MethodPost, "/api/admin/users/{id}/unlock", rolesSupport, h.handleAdminUnlockUser},
+		{http.MethodPut, "/api/admin/users/{id}/role", rolesAdmin, h.handleAdminSetUserRole},
+
+		{http.MethodPost, "/api/admin/withdrawals/{id}/reverse", rolesAdmin, h.handleReverseWithdrawal},
+
+		{http.MethodPost, "/api/admin/orders/{number}/requeue", rolesSupport, h.handleRequeueOrder},
+
+		{http.MethodPost, "/api/admin/webhooks", rolesAdmin, h.handleCreateWebhook},
+		{http.MethodGet, "/api/admin/webhooks", rolesAdmin, h.handleListWebhooks},
+		{http.MethodDelete, "/api/admin/webhooks/{id}", rolesAdmin, h.handleDeleteWebhook},
+		{http.MethodGet, "/api/admin/webhooks/{id}/deliveries", rolesAdmin, h.handleListWebhookDeliveries},
+
+		{http.MethodGet, "/api/admin/audit", rolesAdmin, h.handleAdminListAudit},
+		{http.MethodGet, "/api/admin/audit/verify", rolesAdmin, h.handleAdminVerifyAudit},
+	}
+}
+
 type credentials struct {
 	Login    string `json:"login"`
 	Password string `json:"password"`
@@ -45,11 +139,11 @@ type credentials struct {
 func (h *Handler) handleRegister(w http.ResponseWriter, r *http.Request) {
 	var creds credentials
 	if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
-		http.Error(w, "bad request", http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, codeInvalidJSON, "request body must be a JSON object with login and password")
 		return
 	}
 	if creds.Login == "" || creds.Password == "" {
-		http.Error(w, "login and password required", http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, codeCredentialsRequired, "login and password required")
 		return
 	}
 
@@ -57,29 +151,34 @@ func (h *Handler) handleRegister(w http.ResponseWriter, r *http.Request) {
 
 	taken, err := h.store.IsLoginTaken(ctx, creds.Login)
 	if err != nil {
-		http.Error(w, "internal error", http.StatusInternalServerError)
+		writeInternalError(w, r)
 		return
 	}
 	if taken {
-		http.Error(w, "login already in use", http.StatusConflict) // 409
+		writeProblem(w, r, http.StatusConflict, codeLoginTaken, "login already in use")
 		return
 	}
 
 	hash, err := bcrypt.GenerateFromPassword([]byte(creds.Password), bcrypt.DefaultCost)
 	if err != nil {
-		http.Error(w, "internal error", http.StatusInternalServerError)
+		writeInternalError(w, r)
 		return
 	}
 
 	userID, err := h.store.CreateUser(ctx, creds.Login, string(hash))
 	if err != nil {
-		http.Error(w, "internal error", http.StatusInternalServerError)
+		writeInternalError(w, r)
 		return
 	}
+	h.audit(withAuditActor(r, storage.AuditActorUser, userID), storage.AuditEntry{
+		Action: storage.AuditActionRegister,
+		UserID: userID,
+		After:  map[string]any{"role": storage.RoleUser},
+	})
 
-	token, err := auth.GenerateToken(userID)
+	token, err := auth.GenerateToken(userID, storage.RoleUser, 0)
 	if err != nil {
-		http.Error(w, "internal error", http.StatusInternalServerError)
+		writeInternalError(w, r)
 		return
 	}
 
@@ -96,11 +195,11 @@ func (h *Handler) handleRegister(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) handleLogin(w http.ResponseWriter, r *http.Request) {
 	var creds credentials
 	if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
-		http.Error(w, "bad request", http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, codeInvalidJSON, "request body must be a JSON object with login and password")
 		return
 	}
 	if creds.Login == "" || creds.Password == "" {
-		http.Error(w, "login and password required", http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, codeCredentialsRequired, "login and password required")
 		return
 	}
 
@@ -109,21 +208,32 @@ func (h *Handler) handleLogin(w http.ResponseWriter, r *http.Request) {
 	user, err := h.store.GetUserByLogin(ctx, creds.Login)
 	if err != nil {
 		if err == storage.ErrUserNotFound {
-			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			h.auditLoginFailed(r, 0, creds.Login, "unknown_login")
+			writeProblem(w, r, http.StatusUnauthorized, codeInvalidCredentials, "invalid login or password")
 			return
 		}
-		http.Error(w, "internal error", http.StatusInternalServerError)
+		writeInternalError(w, r)
 		return
 	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(creds.Password)); err != nil {
-		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		h.auditLoginFailed(r, user.ID, creds.Login, "invalid_password")
+		writeProblem(w, r, http.StatusUnauthorized, codeInvalidCredentials, "invalid login or password")
+		return
+	}
+	if user.Locked() {
+		h.auditLoginFailed(r, user.ID, creds.Login, "account_locked")
+		writeAccountLocked(w, r)
 		return
 	}
+	h.audit(withAuditActor(r, storage.AuditActorUser, user.ID), storage.AuditEntry{
+		Action: storage.AuditActionLogin,
+		UserID: user.ID,
+	})
 
-	token, err := auth.GenerateToken(user.ID)
+	token, err := auth.GenerateToken(user.ID, user.Role, user.TokenVersion)
 	if err != nil {
-		http.Error(w, "internal error", http.StatusInternalServerError)
+		writeInternalError(w, r)
 		return
 	}
 
@@ -136,3 +246,18 @@ func (h *Handler) handleLogin(w http.ResponseWriter, r *http.Request) {
 
 	w.WriteHeader(http.StatusOK)
 }
+
+// auditLoginFailed записывает неудачный вход; userID 0 — логин не найден.
+// Введённый логин пишется только в этом случае и, как IP, вне цепочки:
+// он удаляется по сроку хранения персональных данных аудита.
+func (h *Handler) auditLoginFailed(r *http.Request, userID int64, login, reason string) {
+	entry := storage.AuditEntry{
+		Action:  storage.AuditActionLoginFailed,
+		UserID:  userID,
+		Details: map[string]any{"reason": reason},
+	}
+	if userID == 0 {
+		entry.Login = login
+	}
+	h.audit(r, entry)
+}
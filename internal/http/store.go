@@ -0,0 +1,57 @@
+package http
+
+import (
+	"context"
+	"time"
+
+	"github.com/Bekw/go-practicum-diploma/internal/storage"
+)
+
+// Store — методы хранилища, которыми пользуются обработчики. Реализация —
+// *storage.Storage; в тестах контракта её заменяет подделка в памяти.
+type Store interface {
+	Ping(ctx context.Context) error
+
+	CreateUser(ctx context.Context, login, passwordHash string) (int64, error)
+	IsLoginTaken(ctx context.Context, login string) (bool, error)
+	GetUserByID(ctx context.Context, id int64) (*storage.User, error)
+	GetUserByLogin(ctx context.Context, login string) (*storage.User, error)
+	DeleteUser(ctx context.Context, userID int64) error
+	LockUser(ctx context.Context, userID int64, reason string, actorID int64) error
+	UnlockUser(ctx context.Context, userID int64, actorID int64) error
+	ChangeUserRole(ctx context.Context, userID int64, role string, actorID int64) error
+
+	RegisterOrder(ctx context.Context, userID int64, number string) (storage.OrderUploadResult, error)
+	CreateOrdersBatch(ctx context.Context, userID int64, numbers []string) ([]storage.OrderUploadResult, error)
+	GetOrderByNumber(ctx context.Context, number string) (*storage.Order, error)
+	GetOrderHistory(ctx context.Context, orderID int64) ([]storage.OrderStatusChange, error)
+	ListOrdersByUser(ctx context.Context, userID int64) ([]storage.Order, error)
+	ListOrdersByUserPage(ctx context.Context, userID int64, f storage.OrderFilter) ([]storage.Order, *storage.Cursor, error)
+	RequeueOrder(ctx context.Context, number string, actorID int64) (*storage.Order, error)
+	UpdateOrderAccrual(ctx context.Context, number, status string, accrual *float64) error
+
+	GetBalance(ctx context.Context, userID int64) (current, withdrawn float64, err error)
+	CreateWithdrawal(ctx context.Context, userID int64, order string, sum float64, allowDuplicate bool) error
+	ListWithdrawalsByUser(ctx context.Context, userID int64) ([]storage.Withdrawal, error)
+	ListWithdrawalsByUserPage(ctx context.Context, userID int64, after *storage.Cursor, limit int, asc bool) ([]storage.Withdrawal, *storage.Cursor, error)
+	ReverseWithdrawal(ctx context.Context, withdrawalID int64, reason string) (*storage.WithdrawalReversal, error)
+	AdjustBalance(ctx context.Context, userID int64, amount float64, reason string, actorID int64) (*storage.BalanceAdjustment, error)
+	ListLedger(ctx context.Context, userID int64) ([]storage.LedgerEntry, error)
+
+	ReserveIdempotencyKey(ctx context.Context, userID int64, key, requestHash string, ttl time.Duration) (existing *storage.IdempotentResponse, reserved bool, err error)
+	CompleteIdempotencyKey(ctx context.Context, userID int64, key string, resp storage.IdempotentResponse) error
+	ReleaseIdempotencyKey(ctx context.Context, userID int64, key string) error
+
+	ListUserEventsAfter(ctx context.Context, userID, afterID int64, limit int) ([]storage.UserEvent, error)
+
+	CreateWebhookEndpoint(ctx context.Context, url, secret string, events []string) (*storage.WebhookEndpoint, error)
+	ListWebhookEndpoints(ctx context.Context) ([]storage.WebhookEndpoint, error)
+	DeactivateWebhookEndpoint(ctx context.Context, id int64) error
+	ListWebhookDeliveries(ctx context.Context, endpointID int64, limit int) ([]storage.WebhookDelivery, error)
+
+	RecordAudit(ctx context.Context, e storage.AuditEntry) error
+	ListAuditEvents(ctx context.Context, f storage.AuditFilter) ([]storage.AuditEvent, *storage.Cursor, error)
+	VerifyAuditChain(ctx context.Context, anchor *storage.AuditAnchor) (storage.AuditVerification, error)
+}
+
+var _ Store = (*storage.Storage)(nil)
@@ -0,0 +1,268 @@
+package http
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/Bekw/go-practicum-diploma/internal/storage"
+)
+
+// fakeStore — Store в памяти для тестов обработчиков: фиксированный набор
+// пользователей, заказов и записей журнала, без проверок бизнес-правил.
+type fakeStore struct {
+	users       map[int64]*storage.User
+	orders      []storage.Order
+	withdrawals []storage.Withdrawal
+	events      []storage.UserEvent
+	audit       []storage.AuditEvent
+}
+
+var fakeTime = time.Date(2026, 3, 1, 12, 30, 0, 0, time.UTC)
+
+const (
+	fakeUserID      = 1
+	fakeOrderNumber = "12345678903"
+)
+
+func newFakeStore() *fakeStore {
+	accrual := sql.NullFloat64{Float64: 500, Valid: true}
+	return &fakeStore{
+		users: map[int64]*storage.User{
+			fakeUserID: {ID: fakeUserID, Login: "alice", Password: "-", Role: storage.RoleUser, CreatedAt: fakeTime},
+			2: {
+				ID: 2, Login: "bob", Password: "-", Role: storage.RoleUser, CreatedAt: fakeTime,
+				LockedAt:   sql.NullTime{Time: fakeTime, Valid: true},
+				LockReason: sql.NullString{String: "fraud", Valid: true},
+			},
+		},
+		orders: []storage.Order{
+			{ID: 1, Number: fakeOrderNumber, UserID: fakeUserID, Status: storage.OrderStatusProcessed, Accrual: accrual, UploadedAt: fakeTime, UpdatedAt: fakeTime},
+			{ID: 2, Number: "9278923470", UserID: fakeUserID, Status: storage.OrderStatusStalled, UploadedAt: fakeTime, UpdatedAt: fakeTime},
+		},
+		withdrawals: []storage.Withdrawal{
+			{ID: 1, OrderNumber: "2377225624", Sum: 100, ProcessedAt: fakeTime},
+			{ID: 2, OrderNumber: "2377225625", Sum: 50, ProcessedAt: fakeTime, ReversedAt: sql.NullTime{Time: fakeTime, Valid: true}},
+		},
+		audit: []storage.AuditEvent{{
+			ID:           1,
+			CreatedAt:    fakeTime,
+			ActorID:      sql.NullInt64{Int64: fakeUserID, Valid: true},
+			ActorType:    storage.AuditActorUser,
+			Action:       storage.AuditActionUserLock,
+			TargetUserID: sql.NullInt64{Int64: 2, Valid: true},
+			IP:           sql.NullString{String: "192.0.2.1", Valid: true},
+			Before:       json.RawMessage(`{"locked":false}`),
+			After:        json.RawMessage(`{"locked":true}`),
+			HashAlg:      storage.AuditHashSHA256,
+			HashVersion:  2,
+			Hash:         "00",
+		}},
+	}
+}
+
+func (f *fakeStore) Ping(context.Context) error { return nil }
+
+func (f *fakeStore) CreateUser(_ context.Context, login, passwordHash string) (int64, error) {
+	id := int64(len(f.users) + 1)
+	f.users[id] = &storage.User{ID: id, Login: login, Password: passwordHash, Role: storage.RoleUser, CreatedAt: fakeTime}
+	return id, nil
+}
+
+func (f *fakeStore) IsLoginTaken(ctx context.Context, login string) (bool, error) {
+	_, err := f.GetUserByLogin(ctx, login)
+	return err == nil, nil
+}
+
+func (f *fakeStore) GetUserByID(_ context.Context, id int64) (*storage.User, error) {
+	u, ok := f.users[id]
+	if !ok {
+		return nil, storage.ErrUserNotFound
+	}
+	c := *u
+	return &c, nil
+}
+
+func (f *fakeStore) GetUserByLogin(ctx context.Context, login string) (*storage.User, error) {
+	for id, u := range f.users {
+		if u.Login == login {
+			return f.GetUserByID(ctx, id)
+		}
+	}
+	return nil, storage.ErrUserNotFound
+}
+
+func (f *fakeStore) DeleteUser(_ context.Context, userID int64) error {
+	f.users[userID].DeletedAt = sql.NullTime{Time: fakeTime, Valid: true}
+	return nil
+}
+
+func (f *fakeStore) LockUser(_ context.Context, userID int64, reason string, _ int64) error {
+	u, ok := f.users[userID]
+	if !ok {
+		return storage.ErrUserNotFound
+	}
+	u.LockedAt = sql.NullTime{Time: fakeTime, Valid: true}
+	u.LockReason = sql.NullString{String: reason, Valid: reason != ""}
+	return nil
+}
+
+func (f *fakeStore) UnlockUser(_ context.Context, userID int64, _ int64) error {
+	u, ok := f.users[userID]
+	if !ok {
+		return storage.ErrUserNotFound
+	}
+	u.LockedAt, u.LockReason = sql.NullTime{}, sql.NullString{}
+	return nil
+}
+
+func (f *fakeStore) ChangeUserRole(_ context.Context, userID int64, role string, _ int64) error {
+	u, ok := f.users[userID]
+	if !ok {
+		return storage.ErrUserNotFound
+	}
+	u.Role = role
+	return nil
+}
+
+func (f *fakeStore) RegisterOrder(context.Context, int64, string) (storage.OrderUploadResult, error) {
+	return storage.OrderUploadAccepted, nil
+}
+
+func (f *fakeStore) CreateOrdersBatch(_ context.Context, _ int64, numbers []string) ([]storage.OrderUploadResult, error) {
+	res := make([]storage.OrderUploadResult, len(numbers))
+	for i := range res {
+		res[i] = storage.OrderUploadAccepted
+	}
+	return res, nil
+}
+
+func (f *fakeStore) GetOrderByNumber(_ context.Context, number string) (*storage.Order, error) {
+	for _, o := range f.orders {
+		if o.Number == number {
+			return &o, nil
+		}
+	}
+	return nil, storage.ErrOrderNotFound
+}
+
+func (f *fakeStore) GetOrderHistory(context.Context, int64) ([]storage.OrderStatusChange, error) {
+	return []storage.OrderStatusChange{
+		{Status: storage.OrderStatusNew, ChangedAt: fakeTime},
+		{Status: storage.OrderStatusProcessed, Accrual: sql.NullFloat64{Float64: 500, Valid: true}, ChangedAt: fakeTime},
+	}, nil
+}
+
+func (f *fakeStore) ListOrdersByUser(_ context.Context, userID int64) ([]storage.Order, error) {
+	var res []storage.Order
+	for _, o := range f.orders {
+		if o.UserID == userID {
+			res = append(res, o)
+		}
+	}
+	return res, nil
+}
+
+func (f *fakeStore) ListOrdersByUserPage(ctx context.Context, userID int64, _ storage.OrderFilter) ([]storage.Order, *storage.Cursor, error) {
+	res, err := f.ListOrdersByUser(ctx, userID)
+	return res, nil, err
+}
+
+func (f *fakeStore) RequeueOrder(_ context.Context, number string, _ int64) (*storage.Order, error) {
+	for i := range f.orders {
+		if f.orders[i].Number == number {
+			f.orders[i].Status = storage.OrderStatusNew
+			o := f.orders[i]
+			return &o, nil
+		}
+	}
+	return nil, storage.ErrOrderNotFound
+}
+
+func (f *fakeStore) UpdateOrderAccrual(context.Context, string, string, *float64) error { return nil }
+
+func (f *fakeStore) GetBalance(context.Context, int64) (float64, float64, error) {
+	return 350, 150, nil
+}
+
+func (f *fakeStore) CreateWithdrawal(context.Context, int64, string, float64, bool) error { return nil }
+
+func (f *fakeStore) ListWithdrawalsByUser(context.Context, int64) ([]storage.Withdrawal, error) {
+	return f.withdrawals, nil
+}
+
+func (f *fakeStore) ListWithdrawalsByUserPage(context.Context, int64, *storage.Cursor, int, bool) ([]storage.Withdrawal, *storage.Cursor, error) {
+	return f.withdrawals, nil, nil
+}
+
+func (f *fakeStore) ReverseWithdrawal(_ context.Context, withdrawalID int64, reason string) (*storage.WithdrawalReversal, error) {
+	return &storage.WithdrawalReversal{ID: 1, WithdrawalID: withdrawalID, UserID: fakeUserID, Sum: 100, Reason: reason, CreatedAt: fakeTime}, nil
+}
+
+func (f *fakeStore) AdjustBalance(_ context.Context, userID int64, amount float64, reason string, actorID int64) (*storage.BalanceAdjustment, error) {
+	return &storage.BalanceAdjustment{
+		ID: 1, UserID: userID, Amount: amount, Reason: reason,
+		ActorID: sql.NullInt64{Int64: actorID, Valid: actorID != 0}, CreatedAt: fakeTime,
+	}, nil
+}
+
+func (f *fakeStore) ListLedger(context.Context, int64) ([]storage.LedgerEntry, error) {
+	return []storage.LedgerEntry{
+		{Kind: storage.LedgerAccrual, Amount: 500, Order: sql.NullString{String: fakeOrderNumber, Valid: true}, At: fakeTime},
+		{Kind: storage.LedgerWithdrawal, Amount: -100, Order: sql.NullString{String: "2377225624", Valid: true}, At: fakeTime},
+	}, nil
+}
+
+func (f *fakeStore) ReserveIdempotencyKey(context.Context, int64, string, string, time.Duration) (*storage.IdempotentResponse, bool, error) {
+	return nil, true, nil
+}
+
+func (f *fakeStore) CompleteIdempotencyKey(context.Context, int64, string, storage.IdempotentResponse) error {
+	return nil
+}
+
+func (f *fakeStore) ReleaseIdempotencyKey(context.Context, int64, string) error { return nil }
+
+func (f *fakeStore) ListUserEventsAfter(_ context.Context, userID, afterID int64, limit int) ([]storage.UserEvent, error) {
+	var res []storage.UserEvent
+	for _, ev := range f.events {
+		if ev.UserID == userID && ev.ID > afterID && len(res) < limit {
+			res = append(res, ev)
+		}
+	}
+	return res, nil
+}
+
+func (f *fakeStore) CreateWebhookEndpoint(_ context.Context, url, secret string, events []string) (*storage.WebhookEndpoint, error) {
+	return &storage.WebhookEndpoint{ID: 1, URL: url, Secret: secret, Events: events, Active: true, CreatedAt: fakeTime}, nil
+}
+
+func (f *fakeStore) ListWebhookEndpoints(context.Context) ([]storage.WebhookEndpoint, error) {
+	return []storage.WebhookEndpoint{{
+		ID: 1, URL: "https://example.com/hook", Secret: "s",
+		Events: []string{storage.WebhookEventOrderProcessed}, Active: true, CreatedAt: fakeTime,
+	}}, nil
+}
+
+func (f *fakeStore) DeactivateWebhookEndpoint(context.Context, int64) error { return nil }
+
+func (f *fakeStore) ListWebhookDeliveries(_ context.Context, endpointID int64, _ int) ([]storage.WebhookDelivery, error) {
+	return []storage.WebhookDelivery{{
+		ID: 1, OutboxID: 1, EndpointID: endpointID, EventType: storage.WebhookEventOrderProcessed,
+		Payload: json.RawMessage(`{"number":"12345678903"}`), EventAt: fakeTime,
+		Status: storage.WebhookDeliveryFailed, Attempts: 3, NextAttemptAt: fakeTime,
+		LastError: sql.NullString{String: "timeout", Valid: true},
+		LastCode:  sql.NullInt64{Int64: 502, Valid: true},
+	}}, nil
+}
+
+func (f *fakeStore) RecordAudit(context.Context, storage.AuditEntry) error { return nil }
+
+func (f *fakeStore) ListAuditEvents(context.Context, storage.AuditFilter) ([]storage.AuditEvent, *storage.Cursor, error) {
+	return f.audit, &storage.Cursor{At: fakeTime, ID: 1}, nil
+}
+
+func (f *fakeStore) VerifyAuditChain(context.Context, *storage.AuditAnchor) (storage.AuditVerification, error) {
+	return storage.AuditVerification{Checked: len(f.audit)}, nil
+}
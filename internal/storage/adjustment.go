@@ -0,0 +1,157 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// AccrualAdjustment — корректировка начисления по уже обработанному заказу.
+// Исходные status/accrual в orders не меняются: баланс складывается из них
+// и суммы delta всех корректировок, так что история остаётся воспроизводимой.
+type AccrualAdjustment struct {
+	ID              int64
+	OrderID         int64
+	UserID          int64
+	PreviousStatus  string
+	NewStatus       string
+	PreviousAccrual float64
+	NewAccrual      float64
+	Delta           float64
+	CreatedAt       time.Time
+}
+
+// ListOrdersForReconciliation возвращает обработанные (PROCESSED/INVALID)
+// заказы, изменённые за последние window, начиная с давно не сверявшихся.
+func (s *Storage) ListOrdersForReconciliation(ctx context.Context, window time.Duration, limit int) ([]Order, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT id, number, user_id, status, accrual, uploaded_at, updated_at
+         FROM orders
+         WHERE status IN ('PROCESSED', 'INVALID')
+           AND updated_at > now() - make_interval(secs => $1)
+         ORDER BY reconciled_at NULLS FIRST, updated_at
+         LIMIT $2`,
+		window.Seconds(), limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []Order
+	for rows.Next() {
+		var o Order
+		if err := rows.Scan(&o.ID, &o.Number, &o.UserID, &o.Status, &o.Accrual, &o.UploadedAt, &o.UpdatedAt); err != nil {
+			return nil, err
+		}
+		res = append(res, o)
+	}
+	return res, rows.Err()
+}
+
+// ReconcileOrderAccrual сравнивает актуальный результат системы начислений
+// с учтённым (исходное начисление плюс прежние корректировки) и при
+// расхождении записывает корректировку. Если расхождения нет, возвращает nil.
+// В любом случае заказ помечается как сверенный.
+func (s *Storage) ReconcileOrderAccrual(ctx context.Context, orderID int64, status string, accrual *float64) (*AccrualAdjustment, error) {
+	tx, err := s.beginAuditTx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var (
+		userID      int64
+		origStatus  string
+		origAccrual sql.NullFloat64
+	)
+	if err := tx.QueryRowContext(ctx,
+		`SELECT user_id, status, accrual FROM orders WHERE id = $1 FOR UPDATE`,
+		orderID,
+	).Scan(&userID, &origStatus, &origAccrual); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrOrderNotFound
+		}
+		return nil, err
+	}
+
+	prevStatus := origStatus
+	prevAccrual := 0.0
+	if origStatus == OrderStatusProcessed && origAccrual.Valid {
+		prevAccrual = origAccrual.Float64
+	}
+
+	var (
+		lastStatus sql.NullString
+		deltaSum   float64
+	)
+	if err := tx.QueryRowContext(ctx,
+		`SELECT
+             (SELECT new_status FROM accrual_adjustments WHERE order_id = $1 ORDER BY id DESC LIMIT 1),
+             (SELECT COALESCE(SUM(delta), 0) FROM accrual_adjustments WHERE order_id = $1)`,
+		orderID,
+	).Scan(&lastStatus, &deltaSum); err != nil {
+		return nil, err
+	}
+	if lastStatus.Valid {
+		prevStatus = lastStatus.String
+	}
+	prevAccrual += deltaSum
+
+	newAccrual := 0.0
+	if status == OrderStatusProcessed && accrual != nil {
+		newAccrual = *accrual
+	}
+
+	var adj *AccrualAdjustment
+	delta := newAccrual - prevAccrual
+	if status != prevStatus || math.Abs(delta) > 1e-9 {
+		adj = &AccrualAdjustment{
+			OrderID:         orderID,
+			UserID:          userID,
+			PreviousStatus:  prevStatus,
+			NewStatus:       status,
+			PreviousAccrual: prevAccrual,
+			NewAccrual:      newAccrual,
+			Delta:           delta,
+		}
+		if err := tx.QueryRowContext(ctx,
+			`INSERT INTO accrual_adjustments
+                 (order_id, user_id, previous_status, new_status, previous_accrual, new_accrual, delta)
+             VALUES ($1, $2, $3, $4, $5, $6, $7)
+             RETURNING id, created_at`,
+			adj.OrderID, adj.UserID, adj.PreviousStatus, adj.NewStatus, adj.PreviousAccrual, adj.NewAccrual, adj.Delta,
+		).Scan(&adj.ID, &adj.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := publishBalance(ctx, tx, userID); err != nil {
+			return nil, err
+		}
+		if err := s.insertAudit(ctx, tx, AuditEntry{
+			Action:  AuditActionAccrualAdjust,
+			UserID:  userID,
+			Target:  fmt.Sprintf("accrual_adjustment:%d", adj.ID),
+			Before:  map[string]any{"status": prevStatus, "accrual": prevAccrual},
+			After:   map[string]any{"status": status, "accrual": newAccrual},
+			Details: map[string]any{"order_id": orderID, "delta": delta},
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE orders SET reconciled_at = now() WHERE id = $1`,
+		orderID,
+	); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return adj, nil
+}
@@ -0,0 +1,559 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"time"
+)
+
+// Действия, которые пишутся в журнал аудита.
+const (
+	AuditActionRegister          = "auth.register"
+	AuditActionLogin             = "auth.login"
+	AuditActionLoginFailed       = "auth.login_failed"
+	AuditActionWithdraw          = "balance.withdraw"
+	AuditActionBalanceAdjust     = "balance.adjust"
+	AuditActionOrderStatus       = "order.status"
+	AuditActionAccrualAdjust     = "order.accrual_adjust"
+	AuditActionOrderRequeue      = "order.requeue"
+	AuditActionUserView          = "user.view"
+	AuditActionUserLock          = "user.lock"
+	AuditActionUserUnlock        = "user.unlock"
+	AuditActionUserRole          = "user.role"
+	AuditActionUserDelete        = "user.delete"
+	AuditActionUserExport        = "user.export"
+	AuditActionWithdrawalReverse = "withdrawal.reverse"
+	AuditActionWebhookCreate     = "webhook.create"
+	AuditActionWebhookDelete     = "webhook.delete"
+)
+
+// Кто совершил действие.
+const (
+	AuditActorUser      = "user"      // аутентифицированный пользователь, ActorID
+	AuditActorService   = "service"   // сервисный токен администратора
+	AuditActorAccrual   = "accrual"   // push-уведомление системы начислений
+	AuditActorAnonymous = "anonymous" // запрос без аутентификации
+	AuditActorSystem    = "system"    // фоновые процессы без запроса
+)
+
+// AuditMeta — сведения о запросе, в рамках которого пишутся записи аудита.
+// Кладётся в контекст HTTP-слоем; без неё запись считается системной.
+type AuditMeta struct {
+	ActorID   int64
+	ActorType string
+	IP        string
+	UserAgent string
+	RequestID string
+}
+
+type auditMetaKey struct{}
+
+func WithAuditMeta(ctx context.Context, m AuditMeta) context.Context {
+	return context.WithValue(ctx, auditMetaKey{}, m)
+}
+
+func AuditMetaFrom(ctx context.Context) (AuditMeta, bool) {
+	m, ok := ctx.Value(auditMetaKey{}).(AuditMeta)
+	return m, ok
+}
+
+// AuditEntry — действие для журнала. ActorID 0 берётся из AuditMeta
+// контекста, UserID — пользователь, которого касается действие (0 — никто).
+// Before и After — состояние до и после изменения. Login — введённый логин,
+// не принадлежащий известному пользователю (неудачный вход); как и IP,
+// User-Agent и X-Request-ID из AuditMeta, он хранится вне цепочки (см.
+// auditContext) и в Details попадать не должен.
+type AuditEntry struct {
+	ActorID int64
+	Action  string
+	UserID  int64
+	Target  string
+	Before  any
+	After   any
+	Details any
+	Login   string
+}
+
+// AuditEvent — сохранённая запись журнала.
+type AuditEvent struct {
+	ID           int64
+	CreatedAt    time.Time
+	ActorID      sql.NullInt64
+	ActorType    string
+	Action       string
+	TargetUserID sql.NullInt64
+	Target       sql.NullString
+	// IP, UserAgent, RequestID и Login — персональные данные из
+	// audit_event_context; в хеш записи версии 2 не входят.
+	IP          sql.NullString
+	UserAgent   sql.NullString
+	RequestID   sql.NullString
+	Login       sql.NullString
+	Before      json.RawMessage
+	After       json.RawMessage
+	Details     json.RawMessage
+	PrevHash    string
+	HashAlg     string
+	HashVersion int
+	Hash        string
+}
+
+// RecordAudit пишет запись отдельно от изменения, к которому она относится.
+// Изменения, сделанные самим Storage, пишут аудит в своей транзакции.
+func (s *Storage) RecordAudit(ctx context.Context, e AuditEntry) error {
+	tx, err := s.beginAuditTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := s.insertAudit(ctx, tx, e); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// auditChainLock — ключ advisory-блокировки, упорядочивающей записи
+// цепочки: следующая запись читает хеш предыдущей только после её коммита.
+const auditChainLock = 0x61756469 // "audi"
+
+// beginAuditTx начинает транзакцию, которая допишет запись в журнал, и
+// сразу берёт блокировку цепочки. Блокировка держится до коммита, поэтому
+// брать её нужно раньше блокировок строк пользователей и заказов: иначе
+// транзакция, держащая цепочку, ждёт строку, а владелец строки ждёт
+// цепочку в insertAudit. Все транзакции с insertAudit начинаются здесь.
+func (s *Storage) beginAuditTx(ctx context.Context) (*sql.Tx, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, auditChainLock); err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+	return tx, nil
+}
+
+// Алгоритмы хеша записи. Без ключа (Options.AuditKey) хеш — sha256, и
+// переписать журнал вместе с хешами может любой, у кого есть доступ к БД;
+// с ключом — HMAC-SHA256, и для этого нужен ещё и ключ.
+const (
+	AuditHashSHA256     = "sha256"
+	AuditHashHMACSHA256 = "hmac-sha256"
+)
+
+// auditHashVersion — состав хешируемого содержимого записи. В версии 1
+// (записи до появления audit_event_context) в хеш входили IP, User-Agent и
+// X-Request-ID, и они хранятся в самой audit_events; в версии 2 они лежат
+// только в audit_event_context и удаляются вместе с пользователем.
+const auditHashVersion = 2
+
+// ErrAuditKeyMissing — в цепочке есть записи с HMAC, а ключ не задан.
+var ErrAuditKeyMissing = errors.New("audit chain has HMAC records but no audit key is configured")
+
+// insertAudit дописывает запись в цепочку. Вызывается только в транзакции
+// из beginAuditTx.
+func (s *Storage) insertAudit(ctx context.Context, tx *sql.Tx, e AuditEntry) error {
+	ev := AuditEvent{
+		CreatedAt: time.Now().UTC().Truncate(time.Microsecond),
+		ActorType: AuditActorSystem,
+		Action:    e.Action,
+	}
+	if m, ok := AuditMetaFrom(ctx); ok {
+		ev.ActorID = nullID(m.ActorID)
+		ev.ActorType = m.ActorType
+		ev.IP = nullString(m.IP)
+		ev.UserAgent = nullString(m.UserAgent)
+		ev.RequestID = nullString(m.RequestID)
+	}
+	if e.ActorID != 0 {
+		ev.ActorID = nullID(e.ActorID)
+		ev.ActorType = AuditActorUser
+	}
+	ev.TargetUserID = nullID(e.UserID)
+	ev.Target = nullString(e.Target)
+	ev.Login = nullString(e.Login)
+
+	var err error
+	if ev.Before, err = marshalAuditValue(e.Before); err != nil {
+		return err
+	}
+	if ev.After, err = marshalAuditValue(e.After); err != nil {
+		return err
+	}
+	if ev.Details, err = marshalAuditValue(e.Details); err != nil {
+		return err
+	}
+
+	return s.appendAuditEvent(ctx, tx, &ev)
+}
+
+// appendAuditEvent связывает запись с последней в цепочке и сохраняет её;
+// персональные данные записи пишутся в audit_event_context.
+func (s *Storage) appendAuditEvent(ctx context.Context, tx *sql.Tx, ev *AuditEvent) error {
+	var prev string
+	err := tx.QueryRowContext(ctx, `SELECT hash FROM audit_events ORDER BY id DESC LIMIT 1`).Scan(&prev)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if err := ev.seal(prev, s.auditKey); err != nil {
+		return err
+	}
+
+	err = tx.QueryRowContext(
+		ctx,
+		`INSERT INTO audit_events (created_at, actor_id, actor_type, action, target_user_id, target,
+                                   before, after, details, prev_hash, hash_alg, hash_version, hash)
+         VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+         RETURNING id`,
+		ev.CreatedAt, ev.ActorID, ev.ActorType, ev.Action, ev.TargetUserID, ev.Target,
+		jsonArg(ev.Before), jsonArg(ev.After), jsonArg(ev.Details),
+		ev.PrevHash, ev.HashAlg, ev.HashVersion, ev.Hash,
+	).Scan(&ev.ID)
+	if err != nil {
+		return err
+	}
+
+	if !ev.IP.Valid && !ev.UserAgent.Valid && !ev.RequestID.Valid && !ev.Login.Valid {
+		return nil
+	}
+	_, err = tx.ExecContext(
+		ctx,
+		`INSERT INTO audit_event_context (event_id, user_id, ip, user_agent, request_id, login)
+         VALUES ($1, $2, $3, $4, $5, $6)`,
+		ev.ID, ev.contextUserID(), ev.IP, ev.UserAgent, ev.RequestID, ev.Login,
+	)
+	return err
+}
+
+// contextUserID — пользователь, чьи это персональные данные: автор
+// действия, иначе его цель.
+func (ev *AuditEvent) contextUserID() sql.NullInt64 {
+	if ev.ActorType == AuditActorUser && ev.ActorID.Valid {
+		return ev.ActorID
+	}
+	return ev.TargetUserID
+}
+
+// seal связывает запись с предыдущей и считает её хеш: HMAC, если задан
+// key, иначе sha256.
+func (ev *AuditEvent) seal(prev string, key []byte) error {
+	ev.PrevHash = prev
+	ev.HashVersion = auditHashVersion
+	ev.HashAlg = AuditHashSHA256
+	if key != nil {
+		ev.HashAlg = AuditHashHMACSHA256
+	}
+	var err error
+	ev.Hash, err = ev.computeHash(key)
+	return err
+}
+
+// computeHash — хеш предыдущей записи и содержимого записи по алгоритму
+// ev.HashAlg. Поля берутся в том виде, в каком их возвращает БД, поэтому
+// хеш можно пересчитать при проверке. С версии 2 персональные данные
+// заменяются на null: их удаление не ломает цепочку.
+func (ev *AuditEvent) computeHash(key []byte) (string, error) {
+	ip, userAgent, requestID := nullStringPtr(ev.IP), nullStringPtr(ev.UserAgent), nullStringPtr(ev.RequestID)
+	if ev.HashVersion >= 2 {
+		ip, userAgent, requestID = nil, nil, nil
+	}
+
+	content, err := json.Marshal(struct {
+		CreatedAt    string          `json:"created_at"`
+		ActorID      *int64          `json:"actor_id"`
+		ActorType    string          `json:"actor_type"`
+		Action       string          `json:"action"`
+		TargetUserID *int64          `json:"target_user_id"`
+		Target       *string         `json:"target"`
+		IP           *string         `json:"ip"`
+		UserAgent    *string         `json:"user_agent"`
+		RequestID    *string         `json:"request_id"`
+		Before       json.RawMessage `json:"before"`
+		After        json.RawMessage `json:"after"`
+		Details      json.RawMessage `json:"details"`
+	}{
+		CreatedAt:    ev.CreatedAt.UTC().Format(time.RFC3339Nano),
+		ActorID:      nullInt64Ptr(ev.ActorID),
+		ActorType:    ev.ActorType,
+		Action:       ev.Action,
+		TargetUserID: nullInt64Ptr(ev.TargetUserID),
+		Target:       nullStringPtr(ev.Target),
+		IP:           ip,
+		UserAgent:    userAgent,
+		RequestID:    requestID,
+		Before:       rawOrNull(ev.Before),
+		After:        rawOrNull(ev.After),
+		Details:      rawOrNull(ev.Details),
+	})
+	if err != nil {
+		return "", fmt.Errorf("marshal audit event: %w", err)
+	}
+
+	var h hash.Hash
+	switch ev.HashAlg {
+	case AuditHashSHA256:
+		h = sha256.New()
+	case AuditHashHMACSHA256:
+		if key == nil {
+			return "", ErrAuditKeyMissing
+		}
+		h = hmac.New(sha256.New, key)
+	default:
+		return "", fmt.Errorf("unknown audit hash algorithm %q", ev.HashAlg)
+	}
+	h.Write([]byte(ev.PrevHash))
+	h.Write([]byte{'\n'})
+	h.Write(content)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// AuditFilter сужает выборку ListAuditEvents. Нулевые поля не ограничивают.
+type AuditFilter struct {
+	UserID int64     // target_user_id или actor_id
+	From   time.Time // created_at >= From
+	To     time.Time // created_at < To
+	After  *Cursor
+	Limit  int
+	Asc    bool // от старых к новым
+}
+
+// ListAuditEvents возвращает записи от новых к старым (при f.Asc — от
+// старых к новым) и курсор следующей страницы.
+func (s *Storage) ListAuditEvents(ctx context.Context, f AuditFilter) ([]AuditEvent, *Cursor, error) {
+	q := `SELECT ` + auditColumns + ` FROM ` + auditFrom + ` WHERE true`
+	var args []any
+
+	if f.UserID != 0 {
+		args = append(args, f.UserID)
+		q += fmt.Sprintf(" AND (e.target_user_id = $%d OR e.actor_id = $%[1]d)", len(args))
+	}
+	if !f.From.IsZero() {
+		args = append(args, f.From)
+		q += fmt.Sprintf(" AND e.created_at >= $%d", len(args))
+	}
+	if !f.To.IsZero() {
+		args = append(args, f.To)
+		q += fmt.Sprintf(" AND e.created_at < $%d", len(args))
+	}
+	q, args = appendKeyset(q, args, "e.created_at", "e.id", f.After, f.Asc, f.Limit)
+
+	rows, err := s.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var res []AuditEvent
+	for rows.Next() {
+		ev, err := scanAuditEvent(rows)
+		if err != nil {
+			return nil, nil, err
+		}
+		res = append(res, ev)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	if f.Limit > 0 && len(res) > f.Limit {
+		res = res[:f.Limit]
+		last := res[len(res)-1]
+		return res, &Cursor{At: last.CreatedAt, ID: last.ID, Asc: f.Asc}, nil
+	}
+	return res, nil, nil
+}
+
+// AuditAnchor — запись цепочки, хеш которой сохранён вне БД (см.
+// AuditHead). Проверка с якорем замечает и переписанную целиком цепочку, и
+// отрезанный хвост.
+type AuditAnchor struct {
+	ID   int64
+	Hash string
+}
+
+// AuditVerification — итог проверки цепочки.
+type AuditVerification struct {
+	Checked int
+	// BrokenAt — первая запись, хеш или ссылка на предыдущую которой не
+	// сходятся, или якорь, если его запись не найдена или не совпала;
+	// 0, если цепочка цела.
+	BrokenAt int64
+}
+
+// VerifyAuditChain пересчитывает хеши всех записей по порядку. anchor
+// необязателен.
+func (s *Storage) VerifyAuditChain(ctx context.Context, anchor *AuditAnchor) (AuditVerification, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+auditColumns+` FROM `+auditFrom+` ORDER BY e.id`)
+	if err != nil {
+		return AuditVerification{}, err
+	}
+	defer rows.Close()
+
+	c := auditChainVerifier{key: s.auditKey, anchor: anchor}
+	for rows.Next() {
+		ev, err := scanAuditEvent(rows)
+		if err != nil {
+			return c.res, err
+		}
+		if ok, err := c.check(&ev); err != nil || !ok {
+			return c.res, err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return c.res, err
+	}
+	return c.finish(), nil
+}
+
+// AuditHead возвращает последнюю запись цепочки как якорь для внешнего
+// хранения; ok == false, если журнал пуст.
+func (s *Storage) AuditHead(ctx context.Context) (a AuditAnchor, ok bool, err error) {
+	err = s.db.QueryRowContext(ctx, `SELECT id, hash FROM audit_events ORDER BY id DESC LIMIT 1`).Scan(&a.ID, &a.Hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return a, false, nil
+	}
+	return a, err == nil, err
+}
+
+// auditChainVerifier проверяет записи цепочки, поданные по порядку id.
+// После первой записи с HMAC все следующие тоже должны быть с HMAC, иначе
+// хвост можно было бы переписать без ключа.
+type auditChainVerifier struct {
+	key    []byte
+	anchor *AuditAnchor
+
+	res         AuditVerification
+	prev        string
+	keyed       bool
+	anchorFound bool
+}
+
+// check проверяет очередную запись; false — цепочка нарушена на ней,
+// res.BrokenAt заполнен.
+func (c *auditChainVerifier) check(ev *AuditEvent) (bool, error) {
+	c.res.Checked++
+
+	if ev.HashAlg == AuditHashHMACSHA256 {
+		c.keyed = true
+	} else if c.keyed {
+		c.res.BrokenAt = ev.ID
+		return false, nil
+	}
+
+	hash, err := ev.computeHash(c.key)
+	if err != nil {
+		return false, err
+	}
+	if ev.PrevHash != c.prev || ev.Hash != hash {
+		c.res.BrokenAt = ev.ID
+		return false, nil
+	}
+	if c.anchor != nil && ev.ID == c.anchor.ID {
+		if ev.Hash != c.anchor.Hash {
+			c.res.BrokenAt = ev.ID
+			return false, nil
+		}
+		c.anchorFound = true
+	}
+	c.prev = ev.Hash
+	return true, nil
+}
+
+// finish завершает проверку целой цепочки: якорь должен был встретиться.
+func (c *auditChainVerifier) finish() AuditVerification {
+	if c.anchor != nil && !c.anchorFound {
+		c.res.BrokenAt = c.anchor.ID
+	}
+	return c.res
+}
+
+// Записи версии 1 хранят IP, User-Agent и X-Request-ID в audit_events,
+// версии 2 — в audit_event_context.
+const (
+	auditColumns = `e.id, e.created_at, e.actor_id, e.actor_type, e.action, e.target_user_id, e.target,
+       COALESCE(c.ip, e.ip), COALESCE(c.user_agent, e.user_agent), COALESCE(c.request_id, e.request_id), c.login,
+       e.before, e.after, e.details, e.prev_hash, e.hash_alg, e.hash_version, e.hash`
+	auditFrom = `audit_events e LEFT JOIN audit_event_context c ON c.event_id = e.id`
+)
+
+func scanAuditEvent(rows *sql.Rows) (AuditEvent, error) {
+	var (
+		ev                    AuditEvent
+		before, after, detail []byte
+	)
+	err := rows.Scan(&ev.ID, &ev.CreatedAt, &ev.ActorID, &ev.ActorType, &ev.Action, &ev.TargetUserID, &ev.Target,
+		&ev.IP, &ev.UserAgent, &ev.RequestID, &ev.Login, &before, &after, &detail, &ev.PrevHash, &ev.HashAlg, &ev.HashVersion, &ev.Hash)
+	ev.Before, ev.After, ev.Details = before, after, detail
+	return ev, err
+}
+
+func marshalAuditValue(v any) (json.RawMessage, error) {
+	if v == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("marshal audit value: %w", err)
+	}
+	return data, nil
+}
+
+func jsonArg(v json.RawMessage) any {
+	if v == nil {
+		return nil
+	}
+	return []byte(v)
+}
+
+func rawOrNull(v json.RawMessage) json.RawMessage {
+	if v == nil {
+		return json.RawMessage("null")
+	}
+	return v
+}
+
+func nullID(id int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: id, Valid: id != 0}
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func nullInt64Ptr(v sql.NullInt64) *int64 {
+	if !v.Valid {
+		return nil
+	}
+	return &v.Int64
+}
+
+func nullStringPtr(v sql.NullString) *string {
+	if !v.Valid {
+		return nil
+	}
+	return &v.String
+}
+
+// PurgeAuditContext удаляет персональные данные записей журнала старше
+// ttl. Сами записи цепочки остаются, их хеши от этих данных не зависят.
+func (s *Storage) PurgeAuditContext(ctx context.Context, ttl time.Duration) (int64, error) {
+	res, err := s.db.ExecContext(
+		ctx,
+		`DELETE FROM audit_event_context c
+         USING audit_events e
+         WHERE e.id = c.event_id AND e.created_at < now() - make_interval(secs => $1::float8)`,
+		ttl.Seconds(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
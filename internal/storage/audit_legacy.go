@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"time"
+)
+
+// migrateLegacyAuditLog переносит записи из таблицы audit_log, которую вела
+// админка до появления audit_events, в цепочку и удаляет таблицу. Перенос
+// идёт одной транзакцией под блокировкой цепочки, поэтому реплики,
+// стартующие одновременно, не перенесут записи дважды. Исходный id
+// сохраняется в target как "audit_log:<id>".
+func (s *Storage) migrateLegacyAuditLog(ctx context.Context) error {
+	tx, err := s.beginAuditTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.QueryRowContext(ctx, `SELECT to_regclass('audit_log') IS NOT NULL`).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return nil
+	}
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT id, actor_id, action, user_id, details::text, created_at FROM audit_log ORDER BY id`,
+	)
+	if err != nil {
+		return err
+	}
+	var events []AuditEvent
+	for rows.Next() {
+		var (
+			id      int64
+			details string
+			ev      = AuditEvent{ActorType: AuditActorSystem}
+		)
+		if err := rows.Scan(&id, &ev.ActorID, &ev.Action, &ev.TargetUserID, &details, &ev.CreatedAt); err != nil {
+			rows.Close()
+			return err
+		}
+		if ev.ActorID.Valid {
+			ev.ActorType = AuditActorUser
+		}
+		ev.CreatedAt = ev.CreatedAt.UTC().Truncate(time.Microsecond)
+		ev.Target = sql.NullString{String: "audit_log:" + strconv.FormatInt(id, 10), Valid: true}
+		ev.Details = []byte(details)
+		events = append(events, ev)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range events {
+		if err := s.appendAuditEvent(ctx, tx, &events[i]); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `DROP TABLE audit_log`); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,296 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+var testAuditKey = []byte("audit-key")
+
+func newTestAuditEvent(t *testing.T, id int64, action string) AuditEvent {
+	t.Helper()
+
+	details, err := marshalAuditValue(map[string]any{"reason": "проверка", "sum": 12.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return AuditEvent{
+		ID:           id,
+		CreatedAt:    time.Date(2026, 3, 1, 12, 30, 0, 123456000, time.UTC),
+		ActorID:      sql.NullInt64{Int64: 1, Valid: true},
+		ActorType:    AuditActorUser,
+		Action:       action,
+		TargetUserID: sql.NullInt64{Int64: 2, Valid: true},
+		IP:           sql.NullString{String: "192.0.2.1", Valid: true},
+		After:        json.RawMessage(`{"locked":true}`),
+		Details:      details,
+	}
+}
+
+// newTestChain собирает цепочку так же, как appendAuditEvent.
+func newTestChain(t *testing.T, n int, key []byte) []AuditEvent {
+	t.Helper()
+
+	events := make([]AuditEvent, n)
+	prev := ""
+	for i := range events {
+		events[i] = newTestAuditEvent(t, int64(i+1), AuditActionUserLock)
+		if err := events[i].seal(prev, key); err != nil {
+			t.Fatal(err)
+		}
+		prev = events[i].Hash
+	}
+	return events
+}
+
+func verifyTestChain(events []AuditEvent, key []byte, anchor *AuditAnchor) (AuditVerification, error) {
+	c := auditChainVerifier{key: key, anchor: anchor}
+	for i := range events {
+		if ok, err := c.check(&events[i]); err != nil || !ok {
+			return c.res, err
+		}
+	}
+	return c.finish(), nil
+}
+
+// Хеш, посчитанный при вставке, должен совпасть с хешем записи, прочитанной
+// из БД: время приходит в зоне соединения, JSON — теми же байтами.
+func TestAuditHashSurvivesScan(t *testing.T) {
+	for _, key := range [][]byte{nil, testAuditKey} {
+		ev := newTestAuditEvent(t, 0, AuditActionUserLock)
+		if err := ev.seal("prev", key); err != nil {
+			t.Fatal(err)
+		}
+
+		scanned := ev
+		scanned.ID = 42
+		scanned.CreatedAt = ev.CreatedAt.In(time.FixedZone("MSK", 3*60*60))
+		scanned.After = append([]byte(nil), ev.After...)
+		scanned.Details = append([]byte(nil), ev.Details...)
+
+		got, err := scanned.computeHash(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != ev.Hash {
+			t.Errorf("%s: hash after scan %s, at insert %s", ev.HashAlg, got, ev.Hash)
+		}
+	}
+}
+
+func TestAuditHashAlgorithm(t *testing.T) {
+	plain := newTestAuditEvent(t, 1, AuditActionUserLock)
+	keyed := plain
+	if err := plain.seal("", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := keyed.seal("", testAuditKey); err != nil {
+		t.Fatal(err)
+	}
+
+	if plain.HashAlg != AuditHashSHA256 || keyed.HashAlg != AuditHashHMACSHA256 {
+		t.Fatalf("algorithms %s and %s", plain.HashAlg, keyed.HashAlg)
+	}
+	if plain.Hash == keyed.Hash {
+		t.Fatal("HMAC hash equals plain sha256 hash")
+	}
+
+	other := keyed
+	if err := other.seal("", []byte("other-key")); err != nil {
+		t.Fatal(err)
+	}
+	if other.Hash == keyed.Hash {
+		t.Fatal("hash does not depend on key")
+	}
+}
+
+func TestAuditChainVerifier(t *testing.T) {
+	tests := []struct {
+		name     string
+		key      []byte
+		tamper   func(events []AuditEvent) []AuditEvent
+		anchor   func(events []AuditEvent) *AuditAnchor
+		checked  int
+		brokenAt int64
+	}{
+		{name: "intact", checked: 4},
+		{name: "intact with key", key: testAuditKey, checked: 4},
+		{
+			name:     "changed content",
+			tamper:   func(ev []AuditEvent) []AuditEvent { ev[1].Details = json.RawMessage(`{"sum":1000}`); return ev },
+			checked:  2,
+			brokenAt: 2,
+		},
+		{
+			name:     "changed hash",
+			tamper:   func(ev []AuditEvent) []AuditEvent { ev[2].Hash = "00"; return ev },
+			checked:  3,
+			brokenAt: 3,
+		},
+		{
+			name:     "deleted record",
+			tamper:   func(ev []AuditEvent) []AuditEvent { return append(ev[:1], ev[2:]...) },
+			checked:  2,
+			brokenAt: 3,
+		},
+		{
+			name: "rewritten with plain sha256 after keyed records",
+			key:  testAuditKey,
+			tamper: func(ev []AuditEvent) []AuditEvent {
+				ev[3].Action = AuditActionUserUnlock
+				_ = ev[3].seal(ev[2].Hash, nil)
+				return ev
+			},
+			checked:  4,
+			brokenAt: 4,
+		},
+		{
+			name: "rewritten without key",
+			key:  testAuditKey,
+			tamper: func(ev []AuditEvent) []AuditEvent {
+				ev[3].Action = AuditActionUserUnlock
+				_ = ev[3].seal(ev[2].Hash, []byte("guess"))
+				return ev
+			},
+			checked:  4,
+			brokenAt: 4,
+		},
+		{
+			name:    "anchor matches",
+			anchor:  func(ev []AuditEvent) *AuditAnchor { return &AuditAnchor{ID: 3, Hash: ev[2].Hash} },
+			checked: 4,
+		},
+		{
+			name: "chain rewritten consistently",
+			tamper: func(ev []AuditEvent) []AuditEvent {
+				prev := ""
+				for i := range ev {
+					ev[i].Action = AuditActionUserUnlock
+					_ = ev[i].seal(prev, nil)
+					prev = ev[i].Hash
+				}
+				return ev
+			},
+			anchor: func(ev []AuditEvent) *AuditAnchor {
+				return &AuditAnchor{ID: 4, Hash: newTestChain(t, 4, nil)[3].Hash}
+			},
+			checked:  4,
+			brokenAt: 4,
+		},
+		{
+			name:     "tail cut off before anchor",
+			tamper:   func(ev []AuditEvent) []AuditEvent { return ev[:2] },
+			anchor:   func(ev []AuditEvent) *AuditAnchor { return &AuditAnchor{ID: 4, Hash: ev[3].Hash} },
+			checked:  2,
+			brokenAt: 4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events := newTestChain(t, 4, tt.key)
+			var anchor *AuditAnchor
+			if tt.anchor != nil {
+				anchor = tt.anchor(events)
+			}
+			if tt.tamper != nil {
+				events = tt.tamper(events)
+			}
+
+			got, err := verifyTestChain(events, tt.key, anchor)
+			if err != nil {
+				t.Fatalf("verify: %v", err)
+			}
+			if got.Checked != tt.checked || got.BrokenAt != tt.brokenAt {
+				t.Fatalf("verify = %+v, want checked %d broken at %d", got, tt.checked, tt.brokenAt)
+			}
+		})
+	}
+}
+
+func TestAuditChainVerifierNeedsKey(t *testing.T) {
+	events := newTestChain(t, 2, testAuditKey)
+	if _, err := verifyTestChain(events, nil, nil); !errors.Is(err, ErrAuditKeyMissing) {
+		t.Fatalf("err = %v, want ErrAuditKeyMissing", err)
+	}
+}
+
+func TestAuditChainLegacyPrefix(t *testing.T) {
+	// записи до появления ключа остаются sha256, после — HMAC
+	events := newTestChain(t, 2, nil)
+	next := newTestAuditEvent(t, 3, AuditActionUserUnlock)
+	if err := next.seal(events[1].Hash, testAuditKey); err != nil {
+		t.Fatal(err)
+	}
+	events = append(events, next)
+
+	got, err := verifyTestChain(events, testAuditKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.BrokenAt != 0 || got.Checked != 3 {
+		t.Fatalf("verify = %+v, want intact chain of 3", got)
+	}
+}
+
+// Персональные данные записи версии 2 удаляются из audit_event_context, и
+// цепочка после этого должна оставаться целой; у записей версии 1 они
+// по-прежнему входят в хеш.
+func TestAuditHashPersonalData(t *testing.T) {
+	ev := newTestAuditEvent(t, 1, AuditActionLoginFailed)
+	ev.UserAgent = sql.NullString{String: "curl/8.0", Valid: true}
+	ev.RequestID = sql.NullString{String: "req-1", Valid: true}
+	ev.Login = sql.NullString{String: "alice", Valid: true}
+	if err := ev.seal("", testAuditKey); err != nil {
+		t.Fatal(err)
+	}
+	if ev.HashVersion != auditHashVersion {
+		t.Fatalf("hash version %d, want %d", ev.HashVersion, auditHashVersion)
+	}
+
+	erased := ev
+	erased.IP, erased.UserAgent, erased.RequestID, erased.Login = sql.NullString{}, sql.NullString{}, sql.NullString{}, sql.NullString{}
+	got, err := erased.computeHash(testAuditKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != ev.Hash {
+		t.Error("v2 hash depends on personal data")
+	}
+
+	legacy := ev
+	legacy.HashVersion = 1
+	before, err := legacy.computeHash(testAuditKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy.IP = sql.NullString{String: "192.0.2.2", Valid: true}
+	after, err := legacy.computeHash(testAuditKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before == after {
+		t.Error("v1 hash does not depend on ip")
+	}
+}
+
+func TestAuditContextUserID(t *testing.T) {
+	ev := newTestAuditEvent(t, 1, AuditActionUserLock)
+	if got := ev.contextUserID(); got.Int64 != 1 {
+		t.Errorf("user actor: context user %v, want actor 1", got)
+	}
+
+	ev.ActorType = AuditActorService
+	if got := ev.contextUserID(); got.Int64 != 2 {
+		t.Errorf("service actor: context user %v, want target 2", got)
+	}
+
+	ev.ActorType = AuditActorAnonymous
+	ev.ActorID, ev.TargetUserID = sql.NullInt64{}, sql.NullInt64{}
+	if got := ev.contextUserID(); got.Valid {
+		t.Errorf("unknown login: context user %v, want NULL", got)
+	}
+}
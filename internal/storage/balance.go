@@ -3,22 +3,45 @@ package storage
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"time"
 )
 
 type Withdrawal struct {
+	ID          int64
 	OrderNumber string
 	Sum         float64
 	ProcessedAt time.Time
+	ReversedAt  sql.NullTime
 }
 
+const (
+	WithdrawalStatusCompleted = "COMPLETED"
+	WithdrawalStatusReversed  = "REVERSED"
+)
+
+func (w Withdrawal) Status() string {
+	if w.ReversedAt.Valid {
+		return WithdrawalStatusReversed
+	}
+	return WithdrawalStatusCompleted
+}
+
+// accruedSumSQL — начисления пользователя $1 с учётом корректировок сверки
+// и ручных корректировок поддержки.
+const accruedSumSQL = `(SELECT COALESCE(SUM(accrual), 0) FROM orders WHERE user_id = $1 AND status = 'PROCESSED')
+           + (SELECT COALESCE(SUM(delta), 0) FROM accrual_adjustments WHERE user_id = $1)
+           + (SELECT COALESCE(SUM(amount), 0) FROM balance_adjustments WHERE user_id = $1)`
+
+// withdrawnSumSQL — сумма списаний пользователя $1 за вычетом сторнированных.
+const withdrawnSumSQL = `(SELECT COALESCE(SUM(sum), 0) FROM withdrawals WHERE user_id = $1)
+           - (SELECT COALESCE(SUM(sum), 0) FROM withdrawal_reversals WHERE user_id = $1)`
+
 func (s *Storage) GetBalance(ctx context.Context, userID int64) (current, withdrawn float64, err error) {
 	var accrual sql.NullFloat64
 
 	if err = s.db.QueryRowContext(ctx,
-		`SELECT COALESCE(SUM(accrual), 0)
-         FROM orders
-         WHERE user_id = $1 AND status = 'PROCESSED'`,
+		`SELECT `+accruedSumSQL,
 		userID,
 	).Scan(&accrual); err != nil {
 		return
@@ -28,9 +51,7 @@ func (s *Storage) GetBalance(ctx context.Context, userID int64) (current, withdr
 	}
 
 	if err = s.db.QueryRowContext(ctx,
-		`SELECT COALESCE(SUM(sum), 0)
-         FROM withdrawals
-         WHERE user_id = $1`,
+		`SELECT `+withdrawnSumSQL,
 		userID,
 	).Scan(&withdrawn); err != nil {
 		return
@@ -40,35 +61,122 @@ func (s *Storage) GetBalance(ctx context.Context, userID int64) (current, withdr
 	return
 }
 
-func (s *Storage) CreateWithdrawal(ctx context.Context, userID int64, order string, sum float64) error {
-	_, err := s.db.ExecContext(ctx,
+var (
+	ErrInsufficientFunds   = errors.New("insufficient funds")
+	ErrDuplicateWithdrawal = errors.New("withdrawal for this order already exists")
+)
+
+// CreateWithdrawal списывает баллы. Строка пользователя блокируется на время
+// транзакции, поэтому проверка баланса и повторного списания по тому же
+// номеру заказа не гоняются с параллельными запросами.
+func (s *Storage) CreateWithdrawal(ctx context.Context, userID int64, order string, sum float64, allowDuplicate bool) error {
+	tx, err := s.beginAuditTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT 1 FROM users WHERE id = $1 FOR UPDATE`, userID); err != nil {
+		return err
+	}
+
+	if !allowDuplicate {
+		var exists bool
+		if err := tx.QueryRowContext(ctx,
+			`SELECT EXISTS (
+                 SELECT 1 FROM withdrawals w
+                 WHERE w.user_id = $1 AND w.order_number = $2
+                   AND NOT EXISTS (SELECT 1 FROM withdrawal_reversals r WHERE r.withdrawal_id = w.id)
+             )`,
+			userID, order,
+		).Scan(&exists); err != nil {
+			return err
+		}
+		if exists {
+			return ErrDuplicateWithdrawal
+		}
+	}
+
+	var current float64
+	if err := tx.QueryRowContext(ctx,
+		`SELECT (`+accruedSumSQL+`) - (`+withdrawnSumSQL+`)`,
+		userID,
+	).Scan(&current); err != nil {
+		return err
+	}
+	if current+1e-9 < sum {
+		return ErrInsufficientFunds
+	}
+
+	if _, err := tx.ExecContext(ctx,
 		`INSERT INTO withdrawals (user_id, order_number, sum)
          VALUES ($1, $2, $3)`,
 		userID, order, sum,
-	)
-	return err
+	); err != nil {
+		return err
+	}
+
+	if err := publishBalance(ctx, tx, userID); err != nil {
+		return err
+	}
+	if err := enqueueWebhook(ctx, tx, WebhookEventWithdrawalCreate, WithdrawalWebhookPayload{
+		UserID: userID,
+		Order:  order,
+		Sum:    sum,
+	}); err != nil {
+		return err
+	}
+	if err := s.insertAudit(ctx, tx, AuditEntry{
+		Action:  AuditActionWithdraw,
+		UserID:  userID,
+		Target:  "order:" + order,
+		Before:  map[string]any{"balance": current},
+		After:   map[string]any{"balance": current - sum},
+		Details: map[string]any{"sum": sum},
+	}); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 func (s *Storage) ListWithdrawalsByUser(ctx context.Context, userID int64) ([]Withdrawal, error) {
-	rows, err := s.db.QueryContext(ctx,
-		`SELECT order_number, sum, processed_at
-         FROM withdrawals
-         WHERE user_id = $1
-         ORDER BY processed_at DESC`,
-		userID,
-	)
+	items, _, err := s.ListWithdrawalsByUserPage(ctx, userID, nil, 0, false)
+	return items, err
+}
+
+// ListWithdrawalsByUserPage возвращает до limit списаний (0 — без ограничения)
+// от новых к старым (при asc — от старых к новым), начиная после курсора after.
+func (s *Storage) ListWithdrawalsByUserPage(ctx context.Context, userID int64, after *Cursor, limit int, asc bool) ([]Withdrawal, *Cursor, error) {
+	q := `SELECT w.id, w.order_number, w.sum, w.processed_at, r.created_at
+         FROM withdrawals w
+         LEFT JOIN withdrawal_reversals r ON r.withdrawal_id = w.id
+         WHERE w.user_id = $1`
+	args := []any{userID}
+	q, args = appendKeyset(q, args, "w.processed_at", "w.id", after, asc, limit)
+
+	rows, err := s.db.QueryContext(ctx, q, args...)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	defer rows.Close()
 
 	var res []Withdrawal
 	for rows.Next() {
 		var w Withdrawal
-		if err := rows.Scan(&w.OrderNumber, &w.Sum, &w.ProcessedAt); err != nil {
-			return nil, err
+		if err := rows.Scan(&w.ID, &w.OrderNumber, &w.Sum, &w.ProcessedAt, &w.ReversedAt); err != nil {
+			return nil, nil, err
 		}
 		res = append(res, w)
 	}
-	return res, rows.Err()
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	if limit > 0 && len(res) > limit {
+		res = res[:limit]
+		last := res[len(res)-1]
+		return res, &Cursor{At: last.ProcessedAt, ID: last.ID, Asc: asc}, nil
+	}
+	return res, nil, nil
 }
@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// BalanceAdjustment — ручная корректировка баланса поддержкой. Положительная
+// сумма начисляет баллы, отрицательная — списывает.
+type BalanceAdjustment struct {
+	ID        int64
+	UserID    int64
+	Amount    float64
+	Reason    string
+	ActorID   sql.NullInt64
+	CreatedAt time.Time
+}
+
+// AdjustBalance записывает корректировку. Списание, уводящее баланс в
+// минус, отклоняется с ErrInsufficientFunds; строка пользователя
+// блокируется так же, как в CreateWithdrawal.
+func (s *Storage) AdjustBalance(ctx context.Context, userID int64, amount float64, reason string, actorID int64) (*BalanceAdjustment, error) {
+	tx, err := s.beginAuditTx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := tx.QueryRowContext(ctx, `SELECT id FROM users WHERE id = $1 FOR UPDATE`, userID).Scan(&userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	var current float64
+	if err := tx.QueryRowContext(ctx,
+		`SELECT (`+accruedSumSQL+`) - (`+withdrawnSumSQL+`)`,
+		userID,
+	).Scan(&current); err != nil {
+		return nil, err
+	}
+	if amount < 0 && current+amount < -1e-9 {
+		return nil, ErrInsufficientFunds
+	}
+
+	var a BalanceAdjustment
+	if err := tx.QueryRowContext(
+		ctx,
+		`INSERT INTO balance_adjustments (user_id, amount, reason, actor_id)
+         VALUES ($1, $2, $3, $4)
+         RETURNING id, user_id, amount, reason, actor_id, created_at`,
+		userID, amount, reason, nullID(actorID),
+	).Scan(&a.ID, &a.UserID, &a.Amount, &a.Reason, &a.ActorID, &a.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	if err := publishBalance(ctx, tx, userID); err != nil {
+		return nil, err
+	}
+	if err := s.insertAudit(ctx, tx, AuditEntry{
+		ActorID: actorID,
+		Action:  AuditActionBalanceAdjust,
+		UserID:  userID,
+		Target:  fmt.Sprintf("balance_adjustment:%d", a.ID),
+		Before:  map[string]any{"balance": current},
+		After:   map[string]any{"balance": current + amount},
+		Details: map[string]any{"amount": amount, "reason": reason},
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
@@ -0,0 +1,34 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+)
+
+// Cursor — позиция keyset-пагинации: последняя отданная строка (время, id)
+// и направление, в котором выдавались страницы.
+type Cursor struct {
+	At  time.Time
+	ID  int64
+	Asc bool
+}
+
+// appendKeyset дописывает к запросу условие «после курсора», сортировку
+// (от новых к старым, при asc — от старых к новым) и LIMIT. Запрашивается
+// на одну строку больше limit, чтобы понять, есть ли следующая страница.
+func appendKeyset(q string, args []any, timeColumn, idColumn string, after *Cursor, asc bool, limit int) (string, []any) {
+	cmp, dir := "<", "DESC"
+	if asc {
+		cmp, dir = ">", "ASC"
+	}
+	if after != nil {
+		args = append(args, after.At, after.ID)
+		q += fmt.Sprintf(" AND (%s, %s) %s ($%d, $%d)", timeColumn, idColumn, cmp, len(args)-1, len(args))
+	}
+	q += fmt.Sprintf(" ORDER BY %s %s, %s %s", timeColumn, dir, idColumn, dir)
+	if limit > 0 {
+		args = append(args, limit+1)
+		q += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+	return q, args
+}
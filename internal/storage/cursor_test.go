@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAppendKeyset(t *testing.T) {
+	at := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		after *Cursor
+		asc   bool
+		limit int
+		want  string
+		args  int
+	}{
+		{"first page", nil, false, 10, "q ORDER BY t DESC, id DESC LIMIT $2", 2},
+		{"next page", &Cursor{At: at, ID: 5}, false, 10, "q AND (t, id) < ($2, $3) ORDER BY t DESC, id DESC LIMIT $4", 4},
+		{"first page asc", nil, true, 10, "q ORDER BY t ASC, id ASC LIMIT $2", 2},
+		{"next page asc", &Cursor{At: at, ID: 5, Asc: true}, true, 10, "q AND (t, id) > ($2, $3) ORDER BY t ASC, id ASC LIMIT $4", 4},
+		{"no limit", nil, true, 0, "q ORDER BY t ASC, id ASC", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, args := appendKeyset("q", []any{1}, "t", "id", tt.after, tt.asc, tt.limit)
+			if q != tt.want {
+				t.Errorf("query %q, want %q", q, tt.want)
+			}
+			if len(args) != tt.args {
+				t.Errorf("%d args, want %d", len(args), tt.args)
+			}
+			if tt.limit > 0 && args[len(args)-1] != tt.limit+1 {
+				t.Errorf("limit arg %v, want %d", args[len(args)-1], tt.limit+1)
+			}
+		})
+	}
+}
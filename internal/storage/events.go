@@ -0,0 +1,173 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// UserEventsChannel — канал NOTIFY, в который пишется "<user_id>:<event_id>"
+// после коммита транзакции, создавшей событие.
+const UserEventsChannel = "user_events"
+
+const (
+	UserEventOrder   = "order"
+	UserEventBalance = "balance"
+)
+
+// UserEvent — событие для пользователя (смена статуса заказа, баланса).
+// Хранится в таблице, чтобы переподключившийся клиент мог дочитать
+// пропущенное по Last-Event-ID.
+type UserEvent struct {
+	ID        int64
+	UserID    int64
+	Type      string
+	Payload   json.RawMessage
+	CreatedAt time.Time
+}
+
+type OrderEventPayload struct {
+	Number    string   `json:"number"`
+	Status    string   `json:"status"`
+	Accrual   *float64 `json:"accrual,omitempty"`
+	UpdatedAt string   `json:"updated_at"`
+}
+
+type BalanceEventPayload struct {
+	Current   float64 `json:"current"`
+	Withdrawn float64 `json:"withdrawn"`
+}
+
+type execQuerier interface {
+	queryRower
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// publishUserEvent пишет событие пользователю. Вызывается только в
+// транзакции: строка пользователя блокируется до коммита, поэтому события
+// одного пользователя коммитятся по одному и их id растут в порядке
+// коммитов. На этом держится отсечение повторов по Last-Event-ID в потоке:
+// событие с меньшим id не может появиться после большего.
+func publishUserEvent(ctx context.Context, q execQuerier, userID int64, typ string, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshal %s event: %w", typ, err)
+	}
+
+	// NO KEY UPDATE не мешает вставкам, ссылающимся на users (FOR KEY SHARE)
+	if _, err := q.ExecContext(ctx, `SELECT 1 FROM users WHERE id = $1 FOR NO KEY UPDATE`, userID); err != nil {
+		return err
+	}
+
+	_, err = q.ExecContext(
+		ctx,
+		`WITH ins AS (
+             INSERT INTO user_events (user_id, type, payload) VALUES ($1, $2, $3)
+             RETURNING id, user_id
+         )
+         SELECT pg_notify($4, user_id || ':' || id) FROM ins`,
+		userID, typ, data, UserEventsChannel,
+	)
+	return err
+}
+
+// publishBalance публикует текущий баланс пользователя. Вызывается в той же
+// транзакции, что и изменение, поэтому видит собственные изменения.
+func publishBalance(ctx context.Context, q execQuerier, userID int64) error {
+	var b BalanceEventPayload
+	if err := q.QueryRowContext(ctx,
+		`SELECT (`+accruedSumSQL+`), (`+withdrawnSumSQL+`)`,
+		userID,
+	).Scan(&b.Current, &b.Withdrawn); err != nil {
+		return err
+	}
+	b.Current -= b.Withdrawn
+	return publishUserEvent(ctx, q, userID, UserEventBalance, b)
+}
+
+func (s *Storage) GetUserEvent(ctx context.Context, id int64) (*UserEvent, error) {
+	var e UserEvent
+	if err := s.db.QueryRowContext(ctx,
+		`SELECT id, user_id, type, payload, created_at FROM user_events WHERE id = $1`,
+		id,
+	).Scan(&e.ID, &e.UserID, &e.Type, &e.Payload, &e.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// ListUserEventsAfter возвращает события пользователя с id > afterID по возрастанию.
+func (s *Storage) ListUserEventsAfter(ctx context.Context, userID, afterID int64, limit int) ([]UserEvent, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, user_id, type, payload, created_at
+         FROM user_events
+         WHERE user_id = $1 AND id > $2
+         ORDER BY id
+         LIMIT $3`,
+		userID, afterID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []UserEvent
+	for rows.Next() {
+		var e UserEvent
+		if err := rows.Scan(&e.ID, &e.UserID, &e.Type, &e.Payload, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		res = append(res, e)
+	}
+	return res, rows.Err()
+}
+
+// PruneUserEvents удаляет события старше before: возобновление потока
+// возможно только в пределах этого окна.
+func (s *Storage) PruneUserEvents(ctx context.Context, before time.Time) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM user_events WHERE created_at < $1`, before)
+	return err
+}
+
+// Listen держит отдельное соединение с LISTEN channel и вызывает fn на каждое
+// уведомление. Возвращается при ошибке соединения или отмене ctx.
+func (s *Storage) Listen(ctx context.Context, channel string, fn func(payload string)) error {
+	conn, err := pgx.ConnectConfig(ctx, s.connCfg)
+	if err != nil {
+		return fmt.Errorf("connect: %w", err)
+	}
+	defer conn.Close(context.WithoutCancel(ctx))
+
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+		return fmt.Errorf("listen %s: %w", channel, err)
+	}
+
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		fn(n.Payload)
+	}
+}
+
+// ParseUserEventNotification разбирает payload уведомления UserEventsChannel.
+func ParseUserEventNotification(payload string) (userID, eventID int64, err error) {
+	u, e, ok := strings.Cut(payload, ":")
+	if !ok {
+		return 0, 0, fmt.Errorf("bad notification payload %q", payload)
+	}
+	if userID, err = strconv.ParseInt(u, 10, 64); err != nil {
+		return 0, 0, err
+	}
+	if eventID, err = strconv.ParseInt(e, 10, 64); err != nil {
+		return 0, 0, err
+	}
+	return userID, eventID, nil
+}
@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// IdempotentResponse — сохранённый ответ на запрос с Idempotency-Key.
+// StatusCode == 0 означает, что первый запрос с этим ключом ещё выполняется.
+type IdempotentResponse struct {
+	RequestHash string
+	StatusCode  int
+	ContentType string
+	Body        []byte
+}
+
+// ReserveIdempotencyKey занимает ключ за пользователем. Если ключ свободен,
+// брошен незавершённым дольше 5 минут или занят дольше ttl назад
+// (0 — без срока), возвращает reserved == true. Иначе возвращает то, что
+// сохранено под ключом.
+func (s *Storage) ReserveIdempotencyKey(ctx context.Context, userID int64, key, requestHash string, ttl time.Duration) (existing *IdempotentResponse, reserved bool, err error) {
+	var one int
+	err = s.db.QueryRowContext(
+		ctx,
+		`INSERT INTO idempotency_keys (user_id, key, request_hash)
+         VALUES ($1, $2, $3)
+         ON CONFLICT (user_id, key) DO UPDATE
+             SET request_hash = EXCLUDED.request_hash, status_code = NULL, content_type = NULL, body = NULL,
+                 created_at = now()
+             WHERE (idempotency_keys.status_code IS NULL
+                    AND idempotency_keys.created_at < now() - interval '5 minutes')
+                OR ($4::float8 > 0 AND idempotency_keys.created_at < now() - make_interval(secs => $4::float8))
+         RETURNING 1`,
+		userID, key, requestHash, ttl.Seconds(),
+	).Scan(&one)
+	if err == nil {
+		return nil, true, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, false, err
+	}
+
+	var (
+		r       IdempotentResponse
+		status  sql.NullInt64
+		ctype   sql.NullString
+		payload []byte
+	)
+	if err := s.db.QueryRowContext(
+		ctx,
+		`SELECT request_hash, status_code, content_type, body
+         FROM idempotency_keys WHERE user_id = $1 AND key = $2`,
+		userID, key,
+	).Scan(&r.RequestHash, &status, &ctype, &payload); err != nil {
+		return nil, false, err
+	}
+	r.StatusCode = int(status.Int64)
+	r.ContentType = ctype.String
+	r.Body = payload
+
+	return &r, false, nil
+}
+
+func (s *Storage) CompleteIdempotencyKey(ctx context.Context, userID int64, key string, resp IdempotentResponse) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`UPDATE idempotency_keys
+         SET status_code = $3, content_type = $4, body = $5
+         WHERE user_id = $1 AND key = $2`,
+		userID, key, resp.StatusCode, resp.ContentType, resp.Body,
+	)
+	return err
+}
+
+// ReleaseIdempotencyKey освобождает ключ, если запрос не удалось выполнить,
+// чтобы повтор клиента был обработан заново.
+func (s *Storage) ReleaseIdempotencyKey(ctx context.Context, userID int64, key string) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`DELETE FROM idempotency_keys WHERE user_id = $1 AND key = $2 AND status_code IS NULL`,
+		userID, key,
+	)
+	return err
+}
+
+// PurgeIdempotencyKeys удаляет ключи, занятые раньше чем ttl назад: после
+// этого повтор с тем же ключом выполняется как новый запрос. Ключи ещё
+// выполняющихся запросов не трогаются.
+func (s *Storage) PurgeIdempotencyKeys(ctx context.Context, ttl time.Duration) (int64, error) {
+	res, err := s.db.ExecContext(
+		ctx,
+		`DELETE FROM idempotency_keys
+         WHERE created_at < now() - make_interval(secs => $1::float8)
+           AND (status_code IS NOT NULL OR created_at < now() - interval '5 minutes')`,
+		ttl.Seconds(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Виды записей движения баллов.
+const (
+	LedgerAccrual           = "accrual"
+	LedgerAccrualAdjustment = "accrual_adjustment"
+	LedgerBalanceAdjustment = "balance_adjustment"
+	LedgerWithdrawal        = "withdrawal"
+	LedgerReversal          = "withdrawal_reversal"
+)
+
+// LedgerEntry — одно движение баллов пользователя. Amount положителен для
+// поступлений и отрицателен для списаний; сумма всех записей равна
+// текущему балансу.
+type LedgerEntry struct {
+	Kind   string
+	Amount float64
+	// Order — номер заказа, к которому относится запись, если есть.
+	Order  sql.NullString
+	Reason sql.NullString
+	At     time.Time
+}
+
+// ListLedger собирает движения баллов пользователя из начислений,
+// корректировок и списаний в хронологическом порядке.
+func (s *Storage) ListLedger(ctx context.Context, userID int64) ([]LedgerEntry, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT kind, amount, order_number, reason, at FROM (
+             SELECT $2::text AS kind, accrual AS amount, number AS order_number, NULL::text AS reason, updated_at AS at
+             FROM orders
+             WHERE user_id = $1 AND status = 'PROCESSED' AND COALESCE(accrual, 0) <> 0
+             UNION ALL
+             SELECT $3::text, a.delta, o.number, NULL, a.created_at
+             FROM accrual_adjustments a JOIN orders o ON o.id = a.order_id
+             WHERE a.user_id = $1 AND a.delta <> 0
+             UNION ALL
+             SELECT $4::text, amount, NULL, reason, created_at
+             FROM balance_adjustments
+             WHERE user_id = $1
+             UNION ALL
+             SELECT $5::text, -sum, order_number, NULL, processed_at
+             FROM withdrawals
+             WHERE user_id = $1
+             UNION ALL
+             SELECT $6::text, r.sum, w.order_number, r.reason, r.created_at
+             FROM withdrawal_reversals r JOIN withdrawals w ON w.id = r.withdrawal_id
+             WHERE r.user_id = $1
+         ) l
+         ORDER BY at, kind`,
+		userID, LedgerAccrual, LedgerAccrualAdjustment, LedgerBalanceAdjustment, LedgerWithdrawal, LedgerReversal,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []LedgerEntry
+	for rows.Next() {
+		var e LedgerEntry
+		if err := rows.Scan(&e.Kind, &e.Amount, &e.Order, &e.Reason, &e.At); err != nil {
+			return nil, err
+		}
+		res = append(res, e)
+	}
+	return res, rows.Err()
+}
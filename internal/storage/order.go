@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"time"
 )
 
@@ -19,13 +20,89 @@ type Order struct {
 
 var ErrOrderNotFound = errors.New("order not found")
 
-func (s *Storage) CreateOrder(ctx context.Context, userID int64, number string) error {
-	_, err := s.db.ExecContext(
+// NewOrdersChannel — канал NOTIFY, в который пишется при регистрации нового
+// заказа, чтобы обработчик начислений не ждал очередного опроса.
+const NewOrdersChannel = "orders_new"
+
+// OrderUploadResult — итог регистрации одного номера заказа.
+type OrderUploadResult string
+
+const (
+	OrderUploadAccepted         OrderUploadResult = "accepted"
+	OrderUploadAlreadyUploaded  OrderUploadResult = "already_uploaded"
+	OrderUploadOwnedByOtherUser OrderUploadResult = "owned_by_other_user"
+)
+
+// RegisterOrder атомарно регистрирует номер за пользователем. В отличие от
+// проверки через GetOrderByNumber с последующей вставкой, конкурентная
+// загрузка того же номера не приводит к нарушению уникальности:
+// проигравший получает владельца.
+func (s *Storage) RegisterOrder(ctx context.Context, userID int64, number string) (OrderUploadResult, error) {
+	return registerOrder(ctx, s.db, userID, number)
+}
+
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func registerOrder(ctx context.Context, q queryRower, userID int64, number string) (OrderUploadResult, error) {
+	var ownerID int64
+	err := q.QueryRowContext(
 		ctx,
-		`INSERT INTO orders (number, user_id, status) VALUES ($1, $2, $3)`,
-		number, userID, "NEW",
-	)
-	return err
+		`WITH ins AS (
+             INSERT INTO orders (number, user_id, status) VALUES ($1, $2, $3)
+             ON CONFLICT (number) DO NOTHING
+             RETURNING id, user_id, status, uploaded_at
+         ), hist AS (
+             INSERT INTO order_status_history (order_id, status, changed_at)
+             SELECT id, status, uploaded_at FROM ins
+         )
+         SELECT user_id FROM ins, pg_notify($4, '')`,
+		number, userID, OrderStatusNew, NewOrdersChannel,
+	).Scan(&ownerID)
+	if err == nil {
+		return OrderUploadAccepted, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return "", err
+	}
+
+	// строка уже есть: ON CONFLICT дождался коммита конкурента,
+	// и следующий SELECT в read committed её видит
+	if err := q.QueryRowContext(
+		ctx,
+		`SELECT user_id FROM orders WHERE number = $1`,
+		number,
+	).Scan(&ownerID); err != nil {
+		return "", err
+	}
+	if ownerID == userID {
+		return OrderUploadAlreadyUploaded, nil
+	}
+	return OrderUploadOwnedByOtherUser, nil
+}
+
+// CreateOrdersBatch регистрирует номера одной транзакцией. Результаты
+// возвращаются в порядке numbers; повтор номера внутри пачки даёт
+// OrderUploadAlreadyUploaded.
+func (s *Storage) CreateOrdersBatch(ctx context.Context, userID int64, numbers []string) ([]OrderUploadResult, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	res := make([]OrderUploadResult, len(numbers))
+	for i, number := range numbers {
+		if res[i], err = registerOrder(ctx, tx, userID, number); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return res, nil
 }
 
 func (s *Storage) GetOrderByNumber(ctx context.Context, number string) (*Order, error) {
@@ -48,15 +125,46 @@ func (s *Storage) GetOrderByNumber(ctx context.Context, number string) (*Order,
 }
 
 func (s *Storage) ListOrdersByUser(ctx context.Context, userID int64) ([]Order, error) {
-	rows, err := s.db.QueryContext(
-		ctx,
-		`SELECT id, number, user_id, status, accrual, uploaded_at, updated_at
-         FROM orders WHERE user_id = $1
-         ORDER BY uploaded_at DESC`,
-		userID,
-	)
+	orders, _, err := s.ListOrdersByUserPage(ctx, userID, OrderFilter{})
+	return orders, err
+}
+
+// OrderFilter сужает выборку ListOrdersByUserPage. Нулевое значение —
+// все заказы пользователя без ограничения по количеству.
+type OrderFilter struct {
+	Statuses []string
+	From     time.Time // uploaded_at >= From
+	To       time.Time // uploaded_at < To
+	After    *Cursor
+	Limit    int
+	Asc      bool // от старых к новым
+}
+
+// ListOrdersByUserPage возвращает страницу заказов от новых к старым (при
+// f.Asc — от старых к новым) и курсор следующей страницы (nil, если
+// страница последняя).
+func (s *Storage) ListOrdersByUserPage(ctx context.Context, userID int64, f OrderFilter) ([]Order, *Cursor, error) {
+	q := `SELECT id, number, user_id, status, accrual, uploaded_at, updated_at
+         FROM orders WHERE user_id = $1`
+	args := []any{userID}
+
+	if len(f.Statuses) > 0 {
+		args = append(args, f.Statuses)
+		q += fmt.Sprintf(" AND status = ANY($%d)", len(args))
+	}
+	if !f.From.IsZero() {
+		args = append(args, f.From)
+		q += fmt.Sprintf(" AND uploaded_at >= $%d", len(args))
+	}
+	if !f.To.IsZero() {
+		args = append(args, f.To)
+		q += fmt.Sprintf(" AND uploaded_at < $%d", len(args))
+	}
+	q, args = appendKeyset(q, args, "uploaded_at", "id", f.After, f.Asc, f.Limit)
+
+	rows, err := s.db.QueryContext(ctx, q, args...)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	defer rows.Close()
 
@@ -64,19 +172,29 @@ func (s *Storage) ListOrdersByUser(ctx context.Context, userID int64) ([]Order,
 	for rows.Next() {
 		var o Order
 		if err := rows.Scan(&o.ID, &o.Number, &o.UserID, &o.Status, &o.Accrual, &o.UploadedAt, &o.UpdatedAt); err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		res = append(res, o)
 	}
-	return res, rows.Err()
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	if f.Limit > 0 && len(res) > f.Limit {
+		res = res[:f.Limit]
+		last := res[len(res)-1]
+		return res, &Cursor{At: last.UploadedAt, ID: last.ID, Asc: f.Asc}, nil
+	}
+	return res, nil, nil
 }
+
 func (s *Storage) ListOrdersForAccrual(ctx context.Context, limit int) ([]Order, error) {
 	rows, err := s.db.QueryContext(
 		ctx,
 		`SELECT id, number, user_id, status, accrual, uploaded_at, updated_at
          FROM orders
          WHERE status IN ('NEW', 'PROCESSING')
-         ORDER BY uploaded_at
+         ORDER BY COALESCE(requeued_at, uploaded_at)
          LIMIT $1`,
 		limit,
 	)
@@ -95,23 +213,176 @@ func (s *Storage) ListOrdersForAccrual(ctx context.Context, limit int) ([]Order,
 	}
 	return res, rows.Err()
 }
+
+// UpdateOrderAccrual меняет статус и начисление заказа, если переход
+// допустим жизненным циклом. Проверка статуса и обновление выполняются одним
+// условным UPDATE, поэтому запоздавший ответ не откатит заказ назад.
+// Фактическая смена статуса пишется в order_status_history и публикуется
+// пользователю событием (см. UserEventsChannel).
+// Повтор уже применённого окончательного результата (тот же статус и то же
+// начисление) — не ошибка: система начислений может прислать его ещё раз.
+// Ответ по зависшему заказу возвращает его в очередь так же, как
+// RequeueOrder: счётчик опросов и отсчёт возраста сбрасываются, иначе
+// StallOrders сразу вернул бы заказ в STALLED.
+// Недопустимый переход возвращает *TransitionError.
 func (s *Storage) UpdateOrderAccrual(ctx context.Context, number, status string, accrual *float64) error {
+	if accrual != nil && status != OrderStatusProcessed {
+		return ErrUnexpectedAccrual
+	}
+
 	var acc sql.NullFloat64
 	if accrual != nil {
 		acc.Valid = true
 		acc.Float64 = *accrual
-	} else {
-		acc.Valid = false
 	}
 
-	_, err := s.db.ExecContext(
+	tx, err := s.beginAuditTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var (
+		orderID   int64
+		userID    int64
+		oldStatus string
+		updatedAt time.Time
+	)
+	err = tx.QueryRowContext(
 		ctx,
-		`UPDATE orders
+		`UPDATE orders o
          SET status = $2,
              accrual = $3,
+             accrual_attempts = CASE WHEN old.status = $5 THEN 0 ELSE o.accrual_attempts END,
+             requeued_at = CASE WHEN old.status = $5 THEN now() ELSE o.requeued_at END,
              updated_at = now()
-         WHERE number = $1`,
-		number, status, acc,
+         FROM (SELECT id, status FROM orders WHERE number = $1 FOR UPDATE) old
+         WHERE o.id = old.id AND old.status = ANY($4)
+         RETURNING o.id, o.user_id, old.status, o.updated_at`,
+		number, status, acc, allowedFrom(status), OrderStatusStalled,
+	).Scan(&orderID, &userID, &oldStatus, &updatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		o, err := s.GetOrderByNumber(ctx, number)
+		if err != nil {
+			return err
+		}
+		if isRepeatedResult(o, status, accrual) {
+			return nil
+		}
+		return &TransitionError{Number: number, From: o.Status, To: status}
+	}
+	if err != nil {
+		return err
+	}
+
+	if oldStatus != status {
+		if err := recordStatusChange(ctx, tx, orderID, userID, number, status, accrual, updatedAt); err != nil {
+			return err
+		}
+		if err := s.insertAudit(ctx, tx, AuditEntry{
+			Action: AuditActionOrderStatus,
+			UserID: userID,
+			Target: "order:" + number,
+			Before: map[string]any{"status": oldStatus},
+			After:  map[string]any{"status": status, "accrual": accrual},
+		}); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// isRepeatedResult сообщает, что заказ уже находится в статусе status с
+// начислением accrual.
+func isRepeatedResult(o *Order, status string, accrual *float64) bool {
+	if o.Status != status || o.Accrual.Valid != (accrual != nil) {
+		return false
+	}
+	return accrual == nil || o.Accrual.Float64 == *accrual
+}
+
+// recordStatusChange пишет смену статуса в историю и публикует её
+// пользователю и, для окончательных статусов, в вебхуки. Вызывается в
+// транзакции, изменившей заказ.
+func recordStatusChange(ctx context.Context, q execQuerier, orderID, userID int64, number, status string, accrual *float64, updatedAt time.Time) error {
+	var acc sql.NullFloat64
+	if accrual != nil {
+		acc.Valid = true
+		acc.Float64 = *accrual
+	}
+
+	if _, err := q.ExecContext(
+		ctx,
+		`INSERT INTO order_status_history (order_id, status, accrual) VALUES ($1, $2, $3)`,
+		orderID, status, acc,
+	); err != nil {
+		return err
+	}
+
+	if err := publishUserEvent(ctx, q, userID, UserEventOrder, OrderEventPayload{
+		Number:    number,
+		Status:    status,
+		Accrual:   accrual,
+		UpdatedAt: updatedAt.Format(time.RFC3339),
+	}); err != nil {
+		return err
+	}
+	if status == OrderStatusProcessed {
+		if err := publishBalance(ctx, q, userID); err != nil {
+			return err
+		}
+	}
+
+	var hook string
+	switch status {
+	case OrderStatusProcessed:
+		hook = WebhookEventOrderProcessed
+	case OrderStatusInvalid:
+		hook = WebhookEventOrderInvalid
+	case OrderStatusStalled:
+		hook = WebhookEventOrderStalled
+	}
+	if hook == "" {
+		return nil
+	}
+	return enqueueWebhook(ctx, q, hook, OrderWebhookPayload{
+		Number:  number,
+		UserID:  userID,
+		Status:  status,
+		Accrual: accrual,
+	})
+}
+
+// OrderStatusChange — запись истории статусов заказа.
+type OrderStatusChange struct {
+	Status    string
+	Accrual   sql.NullFloat64
+	ChangedAt time.Time
+}
+
+// GetOrderHistory возвращает смены статуса заказа в хронологическом порядке.
+func (s *Storage) GetOrderHistory(ctx context.Context, orderID int64) ([]OrderStatusChange, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT status, accrual, changed_at
+         FROM order_status_history
+         WHERE order_id = $1
+         ORDER BY changed_at, id`,
+		orderID,
 	)
-	return err
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []OrderStatusChange
+	for rows.Next() {
+		var c OrderStatusChange
+		if err := rows.Scan(&c.Status, &c.Accrual, &c.ChangedAt); err != nil {
+			return nil, err
+		}
+		res = append(res, c)
+	}
+	return res, rows.Err()
 }
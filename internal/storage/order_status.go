@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	OrderStatusNew        = "NEW"
+	OrderStatusProcessing = "PROCESSING"
+	OrderStatusProcessed  = "PROCESSED"
+	OrderStatusInvalid    = "INVALID"
+	// OrderStatusStalled — система начислений так и не дала окончательного
+	// ответа за отведённое время или число опросов (см. StallOrders).
+	OrderStatusStalled = "STALLED"
+)
+
+// orderTransitions — жизненный цикл заказа NEW → PROCESSING → PROCESSED/INVALID.
+// Система начислений может сразу ответить окончательным статусом, поэтому
+// NEW допускает переход прямо в PROCESSED/INVALID. Повтор незавершённого
+// статуса разрешён (опрос продолжается), окончательные статусы не меняются.
+// Зависший заказ (STALLED) возвращается в очередь оператором через
+// RequeueOrder, а запоздавший ответ системы начислений (push или опрос,
+// начатый до перевода в STALLED) применяется к нему как обычно.
+var orderTransitions = map[string][]string{
+	OrderStatusNew:        {OrderStatusNew, OrderStatusProcessing, OrderStatusProcessed, OrderStatusInvalid, OrderStatusStalled},
+	OrderStatusProcessing: {OrderStatusProcessing, OrderStatusProcessed, OrderStatusInvalid, OrderStatusStalled},
+	OrderStatusStalled:    {OrderStatusNew, OrderStatusProcessing, OrderStatusProcessed, OrderStatusInvalid},
+}
+
+var (
+	ErrIllegalTransition = errors.New("illegal order status transition")
+	ErrUnexpectedAccrual = errors.New("accrual is only allowed for PROCESSED orders")
+)
+
+// TransitionError — отклонённая смена статуса заказа.
+type TransitionError struct {
+	Number string
+	From   string
+	To     string
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("order %s: %s -> %s: %v", e.Number, e.From, e.To, ErrIllegalTransition)
+}
+
+func (e *TransitionError) Unwrap() error {
+	return ErrIllegalTransition
+}
+
+// allowedFrom возвращает статусы, из которых допустим переход в to.
+func allowedFrom(to string) []string {
+	var res []string
+	for from, targets := range orderTransitions {
+		for _, t := range targets {
+			if t == to {
+				res = append(res, from)
+				break
+			}
+		}
+	}
+	return res
+}
@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func TestIsRepeatedResult(t *testing.T) {
+	acc := func(v float64) *float64 { return &v }
+	processed := &Order{Status: OrderStatusProcessed, Accrual: sql.NullFloat64{Float64: 500, Valid: true}}
+	invalid := &Order{Status: OrderStatusInvalid}
+
+	tests := []struct {
+		name    string
+		order   *Order
+		status  string
+		accrual *float64
+		want    bool
+	}{
+		{"same processed", processed, OrderStatusProcessed, acc(500), true},
+		{"different accrual", processed, OrderStatusProcessed, acc(400), false},
+		{"missing accrual", processed, OrderStatusProcessed, nil, false},
+		{"different final status", processed, OrderStatusInvalid, nil, false},
+		{"same invalid", invalid, OrderStatusInvalid, nil, true},
+		{"invalid to processed", invalid, OrderStatusProcessed, acc(500), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRepeatedResult(tt.order, tt.status, tt.accrual); got != tt.want {
+				t.Errorf("isRepeatedResult() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllowedFromStalled(t *testing.T) {
+	allowed := func(from, to string) bool {
+		for _, s := range allowedFrom(to) {
+			if s == from {
+				return true
+			}
+		}
+		return false
+	}
+
+	// запоздавший ответ системы начислений завершает зависший заказ
+	for _, to := range []string{OrderStatusProcessing, OrderStatusProcessed, OrderStatusInvalid, OrderStatusNew} {
+		if !allowed(OrderStatusStalled, to) {
+			t.Errorf("STALLED -> %s rejected", to)
+		}
+	}
+	for _, from := range []string{OrderStatusProcessed, OrderStatusInvalid, OrderStatusStalled} {
+		if allowed(from, OrderStatusStalled) {
+			t.Errorf("%s -> STALLED allowed", from)
+		}
+	}
+}
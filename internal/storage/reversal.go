@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// WithdrawalReversal — компенсирующая запись к списанию. Исходная строка
+// withdrawals не меняется, баланс восстанавливается за счёт этой записи.
+type WithdrawalReversal struct {
+	ID           int64
+	WithdrawalID int64
+	UserID       int64
+	Sum          float64
+	Reason       string
+	CreatedAt    time.Time
+}
+
+var (
+	ErrWithdrawalNotFound        = errors.New("withdrawal not found")
+	ErrWithdrawalAlreadyReversed = errors.New("withdrawal already reversed")
+)
+
+// ReverseWithdrawal сторнирует списание и пишет это в журнал аудита в той же
+// транзакции. Повторное сторно того же списания отклоняется уникальным
+// ключом по withdrawal_id.
+func (s *Storage) ReverseWithdrawal(ctx context.Context, withdrawalID int64, reason string) (*WithdrawalReversal, error) {
+	tx, err := s.beginAuditTx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var r WithdrawalReversal
+	err = tx.QueryRowContext(
+		ctx,
+		`INSERT INTO withdrawal_reversals (withdrawal_id, user_id, sum, reason)
+         SELECT id, user_id, sum, $2 FROM withdrawals WHERE id = $1
+         ON CONFLICT (withdrawal_id) DO NOTHING
+         RETURNING id, withdrawal_id, user_id, sum, reason, created_at`,
+		withdrawalID, reason,
+	).Scan(&r.ID, &r.WithdrawalID, &r.UserID, &r.Sum, &r.Reason, &r.CreatedAt)
+	if err == nil {
+		if err := publishBalance(ctx, tx, r.UserID); err != nil {
+			return nil, err
+		}
+		if err := s.insertAudit(ctx, tx, AuditEntry{
+			Action:  AuditActionWithdrawalReverse,
+			UserID:  r.UserID,
+			Target:  "withdrawal:" + strconv.FormatInt(r.WithdrawalID, 10),
+			Before:  map[string]any{"reversed": false},
+			After:   map[string]any{"reversed": true},
+			Details: map[string]any{"reversal_id": r.ID, "sum": r.Sum, "reason": r.Reason},
+		}); err != nil {
+			return nil, err
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
+		return &r, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	// ничего не вставлено: либо списания нет, либо оно уже сторнировано
+	var exists bool
+	if err := tx.QueryRowContext(
+		ctx,
+		`SELECT EXISTS (SELECT 1 FROM withdrawals WHERE id = $1)`,
+		withdrawalID,
+	).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrWithdrawalNotFound
+	}
+	return nil, ErrWithdrawalAlreadyReversed
+}
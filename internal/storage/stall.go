@@ -0,0 +1,196 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrOrderNotStalled — заказ нельзя вернуть в очередь: он не в STALLED.
+var ErrOrderNotStalled = errors.New("order is not stalled")
+
+// RecordAccrualAttempts увеличивает счётчик опросов системы начислений
+// для заказов, на которые она ответила.
+func (s *Storage) RecordAccrualAttempts(ctx context.Context, orderIDs []int64) error {
+	if len(orderIDs) == 0 {
+		return nil
+	}
+	_, err := s.db.ExecContext(
+		ctx,
+		`UPDATE orders SET accrual_attempts = accrual_attempts + 1 WHERE id = ANY($1)`,
+		orderIDs,
+	)
+	return err
+}
+
+// stallCondition отбирает незавершённые заказы, ждущие дольше $3 секунд
+// или опрошенные не меньше $4 раз.
+const stallCondition = `status = ANY($2)
+               AND (($3::float8 > 0 AND COALESCE(requeued_at, uploaded_at) < now() - make_interval(secs => $3::float8))
+                 OR ($4::int > 0 AND accrual_attempts >= $4::int))`
+
+// StallOrders переводит в STALLED незавершённые заказы, которые ждут
+// дольше maxAge (с загрузки или последнего возврата в очередь) или
+// опрошены не меньше maxAttempts раз. Нулевое значение отключает
+// соответствующий предел. Заказы каждого пользователя переводятся в
+// отдельной транзакции, чтобы не держать блокировки многих пользователей
+// сразу. Возвращает переведённые заказы, при ошибке — успевшие перейти.
+func (s *Storage) StallOrders(ctx context.Context, maxAge time.Duration, maxAttempts int) ([]Order, error) {
+	if maxAge <= 0 && maxAttempts <= 0 {
+		return nil, nil
+	}
+
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT DISTINCT user_id FROM orders WHERE `+stallCondition+` ORDER BY user_id`,
+		OrderStatusStalled, allowedFrom(OrderStatusStalled), maxAge.Seconds(), maxAttempts,
+	)
+	if err != nil {
+		return nil, err
+	}
+	var users []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		users = append(users, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var res []Order
+	for _, userID := range users {
+		stalled, err := s.stallUserOrders(ctx, userID, maxAge, maxAttempts)
+		if err != nil {
+			return res, err
+		}
+		res = append(res, stalled...)
+	}
+	return res, nil
+}
+
+// stallUserOrders переводит в STALLED подходящие заказы одного
+// пользователя. Условие проверяется заново под блокировкой: за время
+// после выборки заказ мог получить ответ системы начислений.
+func (s *Storage) stallUserOrders(ctx context.Context, userID int64, maxAge time.Duration, maxAttempts int) ([]Order, error) {
+	tx, err := s.beginAuditTx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(
+		ctx,
+		`UPDATE orders o
+         SET status = $1, updated_at = now()
+         FROM (
+             SELECT id, status FROM orders
+             WHERE user_id = $5 AND `+stallCondition+`
+             FOR UPDATE
+         ) old
+         WHERE o.id = old.id
+         RETURNING o.id, o.number, o.user_id, o.status, o.accrual, o.uploaded_at, o.updated_at, old.status`,
+		OrderStatusStalled, allowedFrom(OrderStatusStalled), maxAge.Seconds(), maxAttempts, userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		res  []Order
+		prev []string
+	)
+	for rows.Next() {
+		var (
+			o         Order
+			oldStatus string
+		)
+		if err := rows.Scan(&o.ID, &o.Number, &o.UserID, &o.Status, &o.Accrual, &o.UploadedAt, &o.UpdatedAt, &oldStatus); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		res = append(res, o)
+		prev = append(prev, oldStatus)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, o := range res {
+		if err := recordStatusChange(ctx, tx, o.ID, o.UserID, o.Number, o.Status, nil, o.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if err := s.insertAudit(ctx, tx, AuditEntry{
+			Action: AuditActionOrderStatus,
+			UserID: o.UserID,
+			Target: "order:" + o.Number,
+			Before: map[string]any{"status": prev[i]},
+			After:  map[string]any{"status": o.Status},
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// RequeueOrder возвращает зависший заказ в очередь обработки: статус NEW,
+// счётчик опросов и отсчёт возраста сбрасываются. Действие пишется в журнал
+// аудита от имени actorID.
+func (s *Storage) RequeueOrder(ctx context.Context, number string, actorID int64) (*Order, error) {
+	tx, err := s.beginAuditTx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var o Order
+	err = tx.QueryRowContext(
+		ctx,
+		`UPDATE orders
+         SET status = $2, accrual_attempts = 0, requeued_at = now(), updated_at = now()
+         WHERE number = $1 AND status = $3
+         RETURNING id, number, user_id, status, accrual, uploaded_at, updated_at`,
+		number, OrderStatusNew, OrderStatusStalled,
+	).Scan(&o.ID, &o.Number, &o.UserID, &o.Status, &o.Accrual, &o.UploadedAt, &o.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		if _, err := s.GetOrderByNumber(ctx, number); err != nil {
+			return nil, err
+		}
+		return nil, ErrOrderNotStalled
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := recordStatusChange(ctx, tx, o.ID, o.UserID, o.Number, o.Status, nil, o.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `SELECT pg_notify($1, '')`, NewOrdersChannel); err != nil {
+		return nil, err
+	}
+	if err := s.insertAudit(ctx, tx, AuditEntry{
+		ActorID: actorID,
+		Action:  AuditActionOrderRequeue,
+		UserID:  o.UserID,
+		Target:  "order:" + o.Number,
+		Before:  map[string]any{"status": OrderStatusStalled},
+		After:   map[string]any{"status": o.Status},
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
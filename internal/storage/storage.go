@@ -6,22 +6,37 @@ import (
 	"fmt"
 	"time"
 
-	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/exaring/otelpgx"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
 )
 
 type Storage struct {
-	db *sql.DB
+	db       *sql.DB
+	connCfg  *pgx.ConnConfig
+	auditKey []byte
 }
 
-func New(dsn string) (*Storage, error) {
+// Options — необязательные настройки Storage.
+type Options struct {
+	// AuditKey — ключ HMAC для цепочки журнала аудита. Пустой — записи
+	// хешируются sha256 без ключа.
+	AuditKey string
+}
+
+func New(dsn string, opts Options) (*Storage, error) {
 	if dsn == "" {
 		return nil, fmt.Errorf("empty database dsn")
 	}
 
-	db, err := sql.Open("pgx", dsn)
+	connCfg, err := pgx.ParseConfig(dsn)
 	if err != nil {
-		return nil, fmt.Errorf("open db: %w", err)
+		return nil, fmt.Errorf("parse dsn: %w", err)
 	}
+	// каждый запрос к БД попадает в трейс отдельным спаном
+	connCfg.Tracer = otelpgx.NewTracer()
+
+	db := stdlib.OpenDB(*connCfg)
 
 	db.SetMaxOpenConns(10)
 	db.SetMaxIdleConns(5)
@@ -35,16 +50,31 @@ func New(dsn string) (*Storage, error) {
 		return nil, fmt.Errorf("ping db: %w", err)
 	}
 
-	s := &Storage{db: db}
+	s := &Storage{db: db, connCfg: connCfg}
+	if opts.AuditKey != "" {
+		s.auditKey = []byte(opts.AuditKey)
+	}
 
 	if err := s.initSchema(ctx); err != nil {
 		db.Close()
 		return nil, fmt.Errorf("init schema: %w", err)
 	}
 
+	migrateCtx, cancelMigrate := context.WithTimeout(context.Background(), time.Minute)
+	defer cancelMigrate()
+	if err := s.migrateLegacyAuditLog(migrateCtx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrate audit_log: %w", err)
+	}
+
 	return s, nil
 }
 
+// Ping проверяет, что БД доступна.
+func (s *Storage) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
 func (s *Storage) Close() error {
 	return s.db.Close()
 }
@@ -58,6 +88,19 @@ CREATE TABLE IF NOT EXISTS users (
     created_at  TIMESTAMPTZ NOT NULL DEFAULT now()
 );
 
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'user';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS locked_at TIMESTAMPTZ;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS lock_reason TEXT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS token_version INT NOT NULL DEFAULT 0;
+
+DO $$
+BEGIN
+    ALTER TABLE users ADD CONSTRAINT users_role_check
+        CHECK (role IN ('user', 'support', 'admin', 'merchant'));
+EXCEPTION WHEN duplicate_object THEN NULL;
+END $$;
+
 CREATE TABLE IF NOT EXISTS orders (
     id           BIGSERIAL PRIMARY KEY,
     number       TEXT NOT NULL UNIQUE,
@@ -69,6 +112,51 @@ CREATE TABLE IF NOT EXISTS orders (
 );
 
 CREATE INDEX IF NOT EXISTS idx_orders_user_id ON orders(user_id);
+CREATE INDEX IF NOT EXISTS idx_orders_user_uploaded ON orders(user_id, uploaded_at DESC, id DESC);
+
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS reconciled_at TIMESTAMPTZ;
+
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS accrual_attempts INT NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS requeued_at TIMESTAMPTZ;
+
+-- ограничение пересоздаётся, только если в нём ещё нет STALLED
+DO $$
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1 FROM pg_constraint
+        WHERE conname = 'orders_status_check'
+          AND pg_get_constraintdef(oid) LIKE '%STALLED%'
+    ) THEN
+        ALTER TABLE orders DROP CONSTRAINT IF EXISTS orders_status_check;
+        ALTER TABLE orders ADD CONSTRAINT orders_status_check
+            CHECK (status IN ('NEW', 'PROCESSING', 'PROCESSED', 'INVALID', 'STALLED'));
+    END IF;
+END $$;
+
+CREATE TABLE IF NOT EXISTS order_status_history (
+    id         BIGSERIAL PRIMARY KEY,
+    order_id   BIGINT NOT NULL REFERENCES orders(id),
+    status     TEXT NOT NULL,
+    accrual    DOUBLE PRECISION,
+    changed_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_order_status_history_order_id ON order_status_history(order_id, changed_at);
+
+CREATE TABLE IF NOT EXISTS accrual_adjustments (
+    id               BIGSERIAL PRIMARY KEY,
+    order_id         BIGINT NOT NULL REFERENCES orders(id),
+    user_id          BIGINT NOT NULL REFERENCES users(id),
+    previous_status  TEXT NOT NULL,
+    new_status       TEXT NOT NULL,
+    previous_accrual DOUBLE PRECISION NOT NULL,
+    new_accrual      DOUBLE PRECISION NOT NULL,
+    delta            DOUBLE PRECISION NOT NULL,
+    created_at       TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_accrual_adjustments_user_id ON accrual_adjustments(user_id);
+CREATE INDEX IF NOT EXISTS idx_accrual_adjustments_order_id ON accrual_adjustments(order_id);
 
 CREATE TABLE IF NOT EXISTS withdrawals (
     id           BIGSERIAL PRIMARY KEY,
@@ -79,6 +167,156 @@ CREATE TABLE IF NOT EXISTS withdrawals (
 );
 
 CREATE INDEX IF NOT EXISTS idx_withdrawals_user_id ON withdrawals(user_id);
+CREATE INDEX IF NOT EXISTS idx_withdrawals_user_processed ON withdrawals(user_id, processed_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_withdrawals_user_order ON withdrawals(user_id, order_number);
+
+CREATE TABLE IF NOT EXISTS withdrawal_reversals (
+    id            BIGSERIAL PRIMARY KEY,
+    withdrawal_id BIGINT NOT NULL UNIQUE REFERENCES withdrawals(id),
+    user_id       BIGINT NOT NULL REFERENCES users(id),
+    sum           DOUBLE PRECISION NOT NULL,
+    reason        TEXT NOT NULL,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_withdrawal_reversals_user_id ON withdrawal_reversals(user_id);
+
+CREATE TABLE IF NOT EXISTS user_events (
+    id         BIGSERIAL PRIMARY KEY,
+    user_id    BIGINT NOT NULL REFERENCES users(id),
+    type       TEXT NOT NULL,
+    payload    JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_events_user_id ON user_events(user_id, id);
+CREATE INDEX IF NOT EXISTS idx_user_events_created_at ON user_events(created_at);
+
+CREATE TABLE IF NOT EXISTS webhook_endpoints (
+    id         BIGSERIAL PRIMARY KEY,
+    url        TEXT NOT NULL,
+    secret     TEXT NOT NULL,
+    events     TEXT[] NOT NULL,
+    active     BOOLEAN NOT NULL DEFAULT true,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS webhook_outbox (
+    id            BIGSERIAL PRIMARY KEY,
+    event_type    TEXT NOT NULL,
+    payload       JSONB NOT NULL,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT now(),
+    dispatched_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_outbox_pending ON webhook_outbox(id) WHERE dispatched_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_webhook_outbox_created_at ON webhook_outbox(created_at);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id               BIGSERIAL PRIMARY KEY,
+    outbox_id        BIGINT NOT NULL REFERENCES webhook_outbox(id),
+    endpoint_id      BIGINT NOT NULL REFERENCES webhook_endpoints(id),
+    status           TEXT NOT NULL DEFAULT 'PENDING',
+    attempts         INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_status_code INTEGER,
+    last_error       TEXT,
+    delivered_at     TIMESTAMPTZ,
+    UNIQUE (outbox_id, endpoint_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries(next_attempt_at) WHERE status = 'PENDING';
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_endpoint ON webhook_deliveries(endpoint_id, id DESC);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_outbox ON webhook_deliveries(outbox_id);
+
+CREATE TABLE IF NOT EXISTS webhook_delivery_attempts (
+    id           BIGSERIAL PRIMARY KEY,
+    delivery_id  BIGINT NOT NULL REFERENCES webhook_deliveries(id),
+    attempted_at TIMESTAMPTZ NOT NULL,
+    status_code  INTEGER,
+    error        TEXT,
+    duration_ms  BIGINT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_delivery_attempts_delivery ON webhook_delivery_attempts(delivery_id);
+
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    user_id      BIGINT NOT NULL REFERENCES users(id),
+    key          TEXT NOT NULL,
+    request_hash TEXT NOT NULL,
+    status_code  INTEGER,
+    content_type TEXT,
+    body         BYTEA,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (user_id, key)
+);
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_created_at ON idempotency_keys(created_at);
+
+CREATE TABLE IF NOT EXISTS balance_adjustments (
+    id         BIGSERIAL PRIMARY KEY,
+    user_id    BIGINT NOT NULL REFERENCES users(id),
+    amount     DOUBLE PRECISION NOT NULL CHECK (amount <> 0),
+    reason     TEXT NOT NULL,
+    actor_id   BIGINT REFERENCES users(id),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_balance_adjustments_user_id ON balance_adjustments(user_id);
+
+-- журнал аудита только дополняется; каждая запись хранит хеш предыдущей,
+-- см. audit.go. JSON, а не JSONB: хеш считается по тексту как есть.
+CREATE TABLE IF NOT EXISTS audit_events (
+    id             BIGSERIAL PRIMARY KEY,
+    created_at     TIMESTAMPTZ NOT NULL,
+    actor_id       BIGINT,
+    actor_type     TEXT NOT NULL,
+    action         TEXT NOT NULL,
+    target_user_id BIGINT,
+    target         TEXT,
+    ip             TEXT,
+    user_agent     TEXT,
+    request_id     TEXT,
+    before         JSON,
+    after          JSON,
+    details        JSON,
+    prev_hash      TEXT NOT NULL,
+    hash           TEXT NOT NULL UNIQUE
+);
+ALTER TABLE audit_events ADD COLUMN IF NOT EXISTS hash_alg TEXT NOT NULL DEFAULT 'sha256';
+ALTER TABLE audit_events ADD COLUMN IF NOT EXISTS hash_version INT NOT NULL DEFAULT 1;
+
+-- персональные данные записей аудита; вне цепочки, чтобы их можно было
+-- удалить вместе с пользователем (DeleteUser) и по сроку хранения
+CREATE TABLE IF NOT EXISTS audit_event_context (
+    event_id   BIGINT PRIMARY KEY REFERENCES audit_events(id),
+    user_id    BIGINT,
+    ip         TEXT,
+    user_agent TEXT,
+    request_id TEXT,
+    login      TEXT
+);
+CREATE INDEX IF NOT EXISTS idx_audit_event_context_user ON audit_event_context(user_id);
+CREATE INDEX IF NOT EXISTS idx_audit_events_target_user ON audit_events(target_user_id, created_at, id);
+CREATE INDEX IF NOT EXISTS idx_audit_events_actor ON audit_events(actor_id, created_at, id);
+CREATE INDEX IF NOT EXISTS idx_audit_events_created_at ON audit_events(created_at, id);
+
+CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END $$ LANGUAGE plpgsql;
+
+DO $$
+BEGIN
+    CREATE TRIGGER audit_events_no_change BEFORE UPDATE OR DELETE ON audit_events
+        FOR EACH ROW EXECUTE FUNCTION audit_events_append_only();
+EXCEPTION WHEN duplicate_object THEN NULL;
+END $$;
+
+DO $$
+BEGIN
+    CREATE TRIGGER audit_events_no_truncate BEFORE TRUNCATE ON audit_events
+        FOR EACH STATEMENT EXECUTE FUNCTION audit_events_append_only();
+EXCEPTION WHEN duplicate_object THEN NULL;
+END $$;
 `
 	_, err := s.db.ExecContext(ctx, schema)
 	return err
@@ -7,17 +7,56 @@ import (
 	"time"
 )
 
+// Роли пользователей. Права ролей на маршруты задаются в internal/http.
+const (
+	RoleUser     = "user"
+	RoleSupport  = "support"
+	RoleAdmin    = "admin"
+	RoleMerchant = "merchant"
+)
+
 type User struct {
-	ID        int64
-	Login     string
-	Password  string
-	CreatedAt time.Time
+	ID         int64
+	Login      string
+	Password   string
+	Role       string
+	LockedAt   sql.NullTime
+	LockReason sql.NullString
+	DeletedAt  sql.NullTime
+	// TokenVersion входит в подпись токена и увеличивается при смене роли,
+	// блокировке и удалении: выданные до этого токены перестают действовать.
+	TokenVersion int
+	CreatedAt    time.Time
+}
+
+func (u *User) Locked() bool {
+	return u.LockedAt.Valid
+}
+
+// Deleted сообщает, что учётная запись закрыта пользователем (см. DeleteUser).
+func (u *User) Deleted() bool {
+	return u.DeletedAt.Valid
 }
 
 var (
-	ErrUserNotFound = errors.New("user not found")
+	ErrUserNotFound      = errors.New("user not found")
+	ErrUserAlreadyLocked = errors.New("user already locked")
+	ErrUserNotLocked     = errors.New("user is not locked")
 )
 
+const userColumns = `id, login, password, role, locked_at, lock_reason, deleted_at, token_version, created_at`
+
+func scanUser(row *sql.Row) (*User, error) {
+	var u User
+	if err := row.Scan(&u.ID, &u.Login, &u.Password, &u.Role, &u.LockedAt, &u.LockReason, &u.DeletedAt, &u.TokenVersion, &u.CreatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	return &u, nil
+}
+
 func (s *Storage) CreateUser(ctx context.Context, login, passwordHash string) (int64, error) {
 	var id int64
 	err := s.db.QueryRowContext(
@@ -31,21 +70,19 @@ func (s *Storage) CreateUser(ctx context.Context, login, passwordHash string) (i
 }
 
 func (s *Storage) GetUserByLogin(ctx context.Context, login string) (*User, error) {
-	row := s.db.QueryRowContext(
+	return scanUser(s.db.QueryRowContext(
 		ctx,
-		`SELECT id, login, password, created_at FROM users WHERE login = $1`,
+		`SELECT `+userColumns+` FROM users WHERE login = $1`,
 		login,
-	)
-
-	var u User
-	if err := row.Scan(&u.ID, &u.Login, &u.Password, &u.CreatedAt); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, ErrUserNotFound
-		}
-		return nil, err
-	}
+	))
+}
 
-	return &u, nil
+func (s *Storage) GetUserByID(ctx context.Context, id int64) (*User, error) {
+	return scanUser(s.db.QueryRowContext(
+		ctx,
+		`SELECT `+userColumns+` FROM users WHERE id = $1`,
+		id,
+	))
 }
 
 func (s *Storage) IsLoginTaken(ctx context.Context, login string) (bool, error) {
@@ -66,3 +103,162 @@ func (s *Storage) IsLoginTaken(ctx context.Context, login string) (bool, error)
 
 	return true, nil
 }
+
+// ChangeUserRole меняет роль пользователя от имени actorID и пишет это в
+// журнал аудита. actorID 0 — действие системы (или сервисного токена из
+// контекста). Токены, выданные до смены роли, перестают действовать —
+// в том числе после возврата прежней роли.
+func (s *Storage) ChangeUserRole(ctx context.Context, userID int64, role string, actorID int64) error {
+	tx, err := s.beginAuditTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var oldRole string
+	if err := tx.QueryRowContext(
+		ctx,
+		`UPDATE users u
+         SET role = $2,
+             token_version = u.token_version + CASE WHEN old.role <> $2 THEN 1 ELSE 0 END
+         FROM (SELECT id, role FROM users WHERE id = $1 FOR UPDATE) old
+         WHERE u.id = old.id
+         RETURNING old.role`,
+		userID, role,
+	).Scan(&oldRole); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrUserNotFound
+		}
+		return err
+	}
+
+	if err := s.insertAudit(ctx, tx, AuditEntry{
+		ActorID: actorID,
+		Action:  AuditActionUserRole,
+		UserID:  userID,
+		Before:  map[string]any{"role": oldRole},
+		After:   map[string]any{"role": role},
+	}); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// LockUser блокирует учётную запись: вход и запросы с выданными ранее
+// токенами отклоняются до UnlockUser. Токены, выданные до блокировки, не
+// оживают и после разблокировки.
+func (s *Storage) LockUser(ctx context.Context, userID int64, reason string, actorID int64) error {
+	return s.setUserLock(ctx, userID, actorID, AuditActionUserLock, reason, true)
+}
+
+func (s *Storage) UnlockUser(ctx context.Context, userID int64, actorID int64) error {
+	return s.setUserLock(ctx, userID, actorID, AuditActionUserUnlock, "", false)
+}
+
+func (s *Storage) setUserLock(ctx context.Context, userID, actorID int64, action, reason string, lock bool) error {
+	tx, err := s.beginAuditTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var locked bool
+	if err := tx.QueryRowContext(
+		ctx,
+		`SELECT locked_at IS NOT NULL FROM users WHERE id = $1 FOR UPDATE`,
+		userID,
+	).Scan(&locked); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrUserNotFound
+		}
+		return err
+	}
+	switch {
+	case lock && locked:
+		return ErrUserAlreadyLocked
+	case !lock && !locked:
+		return ErrUserNotLocked
+	}
+
+	if lock {
+		_, err = tx.ExecContext(ctx,
+			`UPDATE users SET locked_at = now(), lock_reason = $2, token_version = token_version + 1 WHERE id = $1`,
+			userID, reason,
+		)
+	} else {
+		_, err = tx.ExecContext(ctx, `UPDATE users SET locked_at = NULL, lock_reason = NULL WHERE id = $1`, userID)
+	}
+	if err != nil {
+		return err
+	}
+
+	var details any
+	if reason != "" {
+		details = map[string]any{"reason": reason}
+	}
+	if err := s.insertAudit(ctx, tx, AuditEntry{
+		ActorID: actorID,
+		Action:  action,
+		UserID:  userID,
+		Before:  map[string]any{"locked": locked},
+		After:   map[string]any{"locked": lock},
+		Details: details,
+	}); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteUser закрывает учётную запись: логин заменяется обезличенным,
+// пароль стирается, и выданные токены перестают действовать. Заказы,
+// списания и корректировки остаются для учёта, но больше не связаны с
+// логином. Сохранённые ответы идемпотентных запросов и персональные данные
+// журнала аудита (IP, User-Agent, X-Request-ID, см. audit_event_context)
+// удаляются; сами записи журнала с идентификатором пользователя остаются.
+// Повторное удаление возвращает ErrUserNotFound.
+func (s *Storage) DeleteUser(ctx context.Context, userID int64) error {
+	tx, err := s.beginAuditTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(
+		ctx,
+		`UPDATE users
+         SET login = 'deleted-' || md5(random()::text || id::text),
+             password = '',
+             lock_reason = NULL,
+             deleted_at = now(),
+             token_version = token_version + 1
+         WHERE id = $1 AND deleted_at IS NULL`,
+		userID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrUserNotFound
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM audit_event_context WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	if err := s.insertAudit(ctx, tx, AuditEntry{
+		Action: AuditActionUserDelete,
+		UserID: userID,
+		Before: map[string]any{"deleted": false},
+		After:  map[string]any{"deleted": true},
+	}); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
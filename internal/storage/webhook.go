@@ -0,0 +1,425 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	WebhookEventOrderProcessed   = "order.processed"
+	WebhookEventOrderInvalid     = "order.invalid"
+	WebhookEventOrderStalled     = "order.stalled"
+	WebhookEventWithdrawalCreate = "withdrawal.created"
+)
+
+const (
+	WebhookDeliveryPending   = "PENDING"
+	WebhookDeliveryDelivered = "DELIVERED"
+	WebhookDeliveryFailed    = "FAILED"
+)
+
+var ErrWebhookNotFound = errors.New("webhook endpoint not found")
+
+type WebhookEndpoint struct {
+	ID        int64
+	URL       string
+	Secret    string
+	Events    []string
+	Active    bool
+	CreatedAt time.Time
+}
+
+// WebhookDelivery — доставка одного события одному получателю.
+type WebhookDelivery struct {
+	ID            int64
+	OutboxID      int64
+	EndpointID    int64
+	EventType     string
+	Payload       json.RawMessage
+	EventAt       time.Time
+	Status        string
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     sql.NullString
+	LastCode      sql.NullInt64
+	DeliveredAt   sql.NullTime
+
+	// заполняются при выборке на отправку
+	URL    string
+	Secret string
+}
+
+type WebhookAttempt struct {
+	DeliveryID  int64
+	AttemptedAt time.Time
+	StatusCode  sql.NullInt64
+	Error       sql.NullString
+	Duration    time.Duration
+}
+
+type OrderWebhookPayload struct {
+	Number  string   `json:"number"`
+	UserID  int64    `json:"user_id"`
+	Status  string   `json:"status"`
+	Accrual *float64 `json:"accrual,omitempty"`
+}
+
+type WithdrawalWebhookPayload struct {
+	UserID int64   `json:"user_id"`
+	Order  string  `json:"order"`
+	Sum    float64 `json:"sum"`
+}
+
+// enqueueWebhook пишет событие в outbox. Вызывается в транзакции, меняющей
+// состояние, поэтому событие появляется тогда и только тогда, когда
+// изменение зафиксировано.
+func enqueueWebhook(ctx context.Context, q execQuerier, eventType string, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshal %s webhook: %w", eventType, err)
+	}
+	_, err = q.ExecContext(ctx,
+		`INSERT INTO webhook_outbox (event_type, payload) VALUES ($1, $2)`,
+		eventType, data,
+	)
+	return err
+}
+
+// CreateWebhookEndpoint регистрирует получателя и пишет это в журнал
+// аудита в той же транзакции.
+func (s *Storage) CreateWebhookEndpoint(ctx context.Context, url, secret string, events []string) (*WebhookEndpoint, error) {
+	tx, err := s.beginAuditTx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	e := WebhookEndpoint{URL: url, Secret: secret, Events: events, Active: true}
+	if err := tx.QueryRowContext(ctx,
+		`INSERT INTO webhook_endpoints (url, secret, events) VALUES ($1, $2, $3)
+         RETURNING id, created_at`,
+		url, secret, events,
+	).Scan(&e.ID, &e.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	if err := s.insertAudit(ctx, tx, AuditEntry{
+		Action: AuditActionWebhookCreate,
+		Target: webhookAuditTarget(e.ID),
+		After:  map[string]any{"url": e.URL, "events": e.Events, "active": true},
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+func webhookAuditTarget(id int64) string {
+	return "webhook:" + strconv.FormatInt(id, 10)
+}
+
+func (s *Storage) ListWebhookEndpoints(ctx context.Context) ([]WebhookEndpoint, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, url, secret, events, active, created_at
+         FROM webhook_endpoints
+         ORDER BY id`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// database/sql не умеет сканировать массивы PostgreSQL напрямую
+	m := pgtype.NewMap()
+
+	var res []WebhookEndpoint
+	for rows.Next() {
+		var e WebhookEndpoint
+		if err := rows.Scan(&e.ID, &e.URL, &e.Secret, m.SQLScanner(&e.Events), &e.Active, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		res = append(res, e)
+	}
+	return res, rows.Err()
+}
+
+// DeactivateWebhookEndpoint отключает получателя. Строка не удаляется,
+// чтобы журнал доставок оставался связным; ещё не доставленные ему события
+// переводятся в FAILED. Отключение пишется в журнал аудита в той же
+// транзакции; повторное отключение ничего не меняет.
+func (s *Storage) DeactivateWebhookEndpoint(ctx context.Context, id int64) error {
+	tx, err := s.beginAuditTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var active bool
+	err = tx.QueryRowContext(ctx,
+		`SELECT active FROM webhook_endpoints WHERE id = $1 FOR UPDATE`,
+		id,
+	).Scan(&active)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrWebhookNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if !active {
+		return nil
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE webhook_endpoints SET active = false WHERE id = $1`,
+		id,
+	); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE webhook_deliveries
+         SET status = $2, last_error = 'endpoint deactivated'
+         WHERE endpoint_id = $1 AND status = $3`,
+		id, WebhookDeliveryFailed, WebhookDeliveryPending,
+	); err != nil {
+		return err
+	}
+
+	if err := s.insertAudit(ctx, tx, AuditEntry{
+		Action: AuditActionWebhookDelete,
+		Target: webhookAuditTarget(id),
+		Before: map[string]any{"active": true},
+		After:  map[string]any{"active": false},
+	}); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// FanOutWebhooks разворачивает неразосланные события outbox в доставки
+// активным подписанным получателям.
+func (s *Storage) FanOutWebhooks(ctx context.Context, limit int) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT id FROM webhook_outbox
+         WHERE dispatched_at IS NULL
+         ORDER BY id
+         LIMIT $1
+         FOR UPDATE SKIP LOCKED`,
+		limit,
+	)
+	if err != nil {
+		return 0, err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO webhook_deliveries (outbox_id, endpoint_id)
+         SELECT o.id, e.id
+         FROM webhook_outbox o
+         JOIN webhook_endpoints e ON e.active AND o.event_type = ANY(e.events)
+         WHERE o.id = ANY($1)
+         ON CONFLICT (outbox_id, endpoint_id) DO NOTHING`,
+		ids,
+	); err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE webhook_outbox SET dispatched_at = now() WHERE id = ANY($1)`,
+		ids,
+	); err != nil {
+		return 0, err
+	}
+
+	return len(ids), tx.Commit()
+}
+
+// ClaimWebhookDeliveries забирает до limit доставок активным получателям,
+// которым пора отправляться, и откладывает их на lease: если процесс упадёт
+// посреди отправки, доставку подхватит другая реплика после истечения
+// lease. lease должен покрывать отправку всех limit доставок.
+func (s *Storage) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]WebhookDelivery, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`WITH due AS (
+             SELECT d.id FROM webhook_deliveries d
+             JOIN webhook_endpoints e ON e.id = d.endpoint_id AND e.active
+             WHERE d.status = 'PENDING' AND d.next_attempt_at <= now()
+             ORDER BY d.next_attempt_at
+             LIMIT $1
+             FOR UPDATE OF d SKIP LOCKED
+         )
+         UPDATE webhook_deliveries d
+         SET next_attempt_at = now() + make_interval(secs => $2)
+         FROM due, webhook_outbox o, webhook_endpoints e
+         WHERE d.id = due.id AND o.id = d.outbox_id AND e.id = d.endpoint_id
+         RETURNING d.id, d.outbox_id, d.endpoint_id, o.event_type, o.payload, o.created_at,
+                   d.status, d.attempts, e.url, e.secret`,
+		limit, lease.Seconds(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []WebhookDelivery
+	for rows.Next() {
+		var d WebhookDelivery
+		if err := rows.Scan(&d.ID, &d.OutboxID, &d.EndpointID, &d.EventType, &d.Payload, &d.EventAt,
+			&d.Status, &d.Attempts, &d.URL, &d.Secret); err != nil {
+			return nil, err
+		}
+		res = append(res, d)
+	}
+	return res, rows.Err()
+}
+
+// RecordWebhookAttempt пишет попытку в журнал и переводит доставку в
+// DELIVERED, FAILED или назначает следующую попытку на retryAt. Доставка,
+// отменённая отключением получателя во время отправки, остаётся FAILED.
+func (s *Storage) RecordWebhookAttempt(ctx context.Context, a WebhookAttempt, status string, retryAt time.Time) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO webhook_delivery_attempts (delivery_id, attempted_at, status_code, error, duration_ms)
+         VALUES ($1, $2, $3, $4, $5)`,
+		a.DeliveryID, a.AttemptedAt, a.StatusCode, a.Error, a.Duration.Milliseconds(),
+	); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE webhook_deliveries
+         SET status = $2,
+             attempts = attempts + 1,
+             next_attempt_at = $3,
+             last_status_code = $4,
+             last_error = $5,
+             delivered_at = CASE WHEN $2 = 'DELIVERED' THEN now() END
+         WHERE id = $1 AND status = $6`,
+		a.DeliveryID, status, retryAt, a.StatusCode, a.Error, WebhookDeliveryPending,
+	); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ListWebhookDeliveries — журнал доставок получателя, от новых к старым.
+func (s *Storage) ListWebhookDeliveries(ctx context.Context, endpointID int64, limit int) ([]WebhookDelivery, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT d.id, d.outbox_id, d.endpoint_id, o.event_type, o.payload, o.created_at,
+                d.status, d.attempts, d.next_attempt_at, d.last_error, d.last_status_code, d.delivered_at
+         FROM webhook_deliveries d
+         JOIN webhook_outbox o ON o.id = d.outbox_id
+         WHERE d.endpoint_id = $1
+         ORDER BY d.id DESC
+         LIMIT $2`,
+		endpointID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []WebhookDelivery
+	for rows.Next() {
+		var d WebhookDelivery
+		if err := rows.Scan(&d.ID, &d.OutboxID, &d.EndpointID, &d.EventType, &d.Payload, &d.EventAt,
+			&d.Status, &d.Attempts, &d.NextAttemptAt, &d.LastError, &d.LastCode, &d.DeliveredAt); err != nil {
+			return nil, err
+		}
+		res = append(res, d)
+	}
+	return res, rows.Err()
+}
+
+// PurgeWebhookHistory удаляет разосланные события outbox старше before
+// вместе с их доставками и журналом попыток. События с недоставленными
+// ещё доставками не трогаются. Удаляет не больше limit событий за вызов.
+func (s *Storage) PurgeWebhookHistory(ctx context.Context, before time.Time, limit int) (int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT o.id FROM webhook_outbox o
+         WHERE o.dispatched_at IS NOT NULL AND o.created_at < $1
+           AND NOT EXISTS (
+               SELECT 1 FROM webhook_deliveries d WHERE d.outbox_id = o.id AND d.status = $2
+           )
+         ORDER BY o.id
+         LIMIT $3
+         FOR UPDATE SKIP LOCKED`,
+		before, WebhookDeliveryPending, limit,
+	)
+	if err != nil {
+		return 0, err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	for _, q := range []string{
+		`DELETE FROM webhook_delivery_attempts a
+         USING webhook_deliveries d
+         WHERE a.delivery_id = d.id AND d.outbox_id = ANY($1)`,
+		`DELETE FROM webhook_deliveries WHERE outbox_id = ANY($1)`,
+		`DELETE FROM webhook_outbox WHERE id = ANY($1)`,
+	} {
+		if _, err := tx.ExecContext(ctx, q, ids); err != nil {
+			return 0, err
+		}
+	}
+
+	return int64(len(ids)), tx.Commit()
+}
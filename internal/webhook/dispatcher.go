@@ -0,0 +1,219 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+
+	"github.com/Bekw/go-practicum-diploma/internal/storage"
+)
+
+const (
+	pollInterval   = 2 * time.Second
+	fanOutBatch    = 20
+	claimBatch     = 5
+	requestTimeout = 10 * time.Second
+	// lease покрывает отправку всей пачки по requestTimeout с запасом,
+	// иначе другая реплика заберёт доставку, пока эта ещё её отправляет.
+	lease = claimBatch*requestTimeout + 30*time.Second
+
+	// retention — сколько хранятся разосланные события и журнал доставок.
+	retention     = 30 * 24 * time.Hour
+	purgeInterval = time.Hour
+	purgeBatch    = 1000
+
+	maxAttempts = 10
+	baseBackoff = 10 * time.Second
+	maxBackoff  = time.Hour
+
+	SignatureHeader = "X-Gophermart-Signature"
+	TimestampHeader = "X-Gophermart-Timestamp"
+	EventHeader     = "X-Gophermart-Event"
+	DeliveryHeader  = "X-Gophermart-Delivery"
+)
+
+// Envelope — тело запроса к получателю.
+type Envelope struct {
+	ID        int64           `json:"id"`
+	Type      string          `json:"type"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// Dispatcher разворачивает события outbox в доставки и отправляет их с
+// повторами по экспоненциальной задержке. Все попытки пишутся в журнал.
+type Dispatcher struct {
+	store  *storage.Storage
+	client *http.Client
+}
+
+func NewDispatcher(store *storage.Storage) *Dispatcher {
+	return &Dispatcher{
+		store: store,
+		client: &http.Client{
+			Timeout:   requestTimeout,
+			Transport: otelhttp.NewTransport(http.DefaultTransport),
+		},
+	}
+}
+
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	purge := time.NewTicker(purgeInterval)
+	defer purge.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-purge.C:
+			if err := d.purge(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("webhooks: purge: %v", err)
+			}
+		case <-ticker.C:
+			if _, err := d.store.FanOutWebhooks(ctx, fanOutBatch); err != nil && ctx.Err() == nil {
+				log.Printf("webhooks: fan out: %v", err)
+			}
+			if err := d.deliverDue(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("webhooks: deliver: %v", err)
+			}
+		}
+	}
+}
+
+// deliverDue отправляет доставки небольшими пачками, пока они не кончатся:
+// каждая пачка забирается заново, чтобы lease не истёк посреди отправки.
+func (d *Dispatcher) deliverDue(ctx context.Context) error {
+	for ctx.Err() == nil {
+		n, err := d.deliverBatch(ctx)
+		if err != nil {
+			return err
+		}
+		if n < claimBatch {
+			return nil
+		}
+	}
+	return ctx.Err()
+}
+
+func (d *Dispatcher) deliverBatch(ctx context.Context) (int, error) {
+	items, err := d.store.ClaimWebhookDeliveries(ctx, claimBatch, lease)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, it := range items {
+		attempt := d.send(ctx, &it)
+
+		// send заполняет Error и для ответов не из 2xx
+		status := storage.WebhookDeliveryDelivered
+		if attempt.Error.Valid {
+			status = storage.WebhookDeliveryPending
+			if it.Attempts+1 >= maxAttempts {
+				status = storage.WebhookDeliveryFailed
+			}
+		}
+		retryAt := time.Now().Add(backoff(it.Attempts + 1))
+
+		if err := d.store.RecordWebhookAttempt(ctx, attempt, status, retryAt); err != nil {
+			return 0, err
+		}
+	}
+	return len(items), nil
+}
+
+// purge удаляет историю старше retention пачками по purgeBatch.
+func (d *Dispatcher) purge(ctx context.Context) error {
+	before := time.Now().Add(-retention)
+	var total int64
+	for {
+		n, err := d.store.PurgeWebhookHistory(ctx, before, purgeBatch)
+		if err != nil {
+			return err
+		}
+		total += n
+		if n < purgeBatch {
+			break
+		}
+	}
+	if total > 0 {
+		log.Printf("webhooks: purged %d events older than %s", total, retention)
+	}
+	return nil
+}
+
+func (d *Dispatcher) send(ctx context.Context, it *storage.WebhookDelivery) storage.WebhookAttempt {
+	attempt := storage.WebhookAttempt{DeliveryID: it.ID, AttemptedAt: time.Now()}
+	fail := func(err error) storage.WebhookAttempt {
+		attempt.Duration = time.Since(attempt.AttemptedAt)
+		attempt.Error = sql.NullString{String: err.Error(), Valid: true}
+		return attempt
+	}
+
+	body, err := json.Marshal(Envelope{
+		ID:        it.OutboxID,
+		Type:      it.EventType,
+		CreatedAt: it.EventAt,
+		Data:      it.Payload,
+	})
+	if err != nil {
+		return fail(err)
+	}
+
+	ts := strconv.FormatInt(attempt.AttemptedAt.Unix(), 10)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, it.URL, bytes.NewReader(body))
+	if err != nil {
+		return fail(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, it.EventType)
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(it.ID, 10))
+	req.Header.Set(TimestampHeader, ts)
+	req.Header.Set(SignatureHeader, "sha256="+Sign(it.Secret, ts, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return fail(err)
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+
+	attempt.Duration = time.Since(attempt.AttemptedAt)
+	attempt.StatusCode = sql.NullInt64{Int64: int64(resp.StatusCode), Valid: true}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		attempt.Error = sql.NullString{String: fmt.Sprintf("unexpected status %d", resp.StatusCode), Valid: true}
+	}
+	return attempt
+}
+
+// Sign считает подпись HMAC-SHA256 от "<timestamp>.<body>". Временная метка
+// входит в подпись, чтобы получатель мог отбрасывать старые повторы.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func backoff(attempt int) time.Duration {
+	d := baseBackoff
+	for i := 1; i < attempt && d < maxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, maxBackoff)
+}
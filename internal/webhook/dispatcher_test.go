@@ -0,0 +1,57 @@
+package webhook
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSign(t *testing.T) {
+	got := Sign("secret", "1700000000", []byte(`{"id":1}`))
+	want := "3dd1b9aef568d75f6790a84bd2e5dfa1f44409eef3cbdbd3f10b837376100c11"
+	if got != want {
+		t.Fatalf("Sign() = %s, want %s", got, want)
+	}
+
+	if Sign("other", "1700000000", []byte(`{"id":1}`)) == want {
+		t.Fatal("signature does not depend on secret")
+	}
+	if Sign("secret", "1700000001", []byte(`{"id":1}`)) == want {
+		t.Fatal("signature does not depend on timestamp")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{0, baseBackoff},
+		{1, baseBackoff},
+		{2, 2 * baseBackoff},
+		{3, 4 * baseBackoff},
+		{9, 256 * baseBackoff},
+		{10, maxBackoff},
+		{maxAttempts, maxBackoff},
+		{1000, maxBackoff},
+	}
+	for _, tt := range tests {
+		if got := backoff(tt.attempt); got != tt.want {
+			t.Errorf("backoff(%d) = %s, want %s", tt.attempt, got, tt.want)
+		}
+	}
+
+	prev := time.Duration(0)
+	for a := 1; a <= 64; a++ {
+		d := backoff(a)
+		if d < prev || d > maxBackoff {
+			t.Fatalf("backoff(%d) = %s, previous %s, max %s", a, d, prev, maxBackoff)
+		}
+		prev = d
+	}
+}
+
+func TestLeaseCoversBatch(t *testing.T) {
+	if lease <= claimBatch*requestTimeout {
+		t.Fatalf("lease %s does not cover %d requests of %s", lease, claimBatch, requestTimeout)
+	}
+}